@@ -0,0 +1,176 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Opacity of the shading drawn over the time spanned by an annotation.
+const spanOpacity = 0.18
+
+// Maximum number of rows to stagger annotation labels across before dropping
+// the labels which would overlap.
+const annotationRows = 4
+
+// Annotation marks an external event, such as a deploy or an incident, on the
+// time axis of a visualization. Annotations with an end time are drawn as a
+// shaded span, and those without are drawn as a vertical marker. Times are in
+// seconds since the beginning of the Unix epoch, and the color is given as a
+// "#rrggbb" or "#rgb" hex string, with the theme's text color used if none is
+// specified.
+type Annotation struct {
+	Time  int    `json:"time"`
+	End   int    `json:"end,omitempty"`
+	Label string `json:"label"`
+	Color string `json:"color,omitempty"`
+}
+
+// Annotatable is implemented by visualization generators with a time axis,
+// which can draw annotations over their visualizations.
+type Annotatable interface {
+	Annotate([]Annotation)
+}
+
+// ParseAnnotations reads a JSON array of annotations, returning an error if
+// the input is malformed or if any annotation has an unrecognized color.
+func ParseAnnotations(in io.Reader) ([]Annotation, error) {
+	var annotations []Annotation
+	if err := json.NewDecoder(in).Decode(&annotations); err != nil {
+		return nil, err
+	}
+	for _, a := range annotations {
+		if _, ok := parseHexColor(a.Color); a.Color != "" && !ok {
+			return nil, fmt.Errorf(
+				"unrecognized color for annotation \"%s\": \"%s\"",
+				a.Label,
+				a.Color)
+		}
+	}
+	return annotations, nil
+}
+
+// An annotation, positioned on a visualization.
+type annotationMark struct {
+	x0    int        // Left edge of the annotation
+	x1    int        // Right edge of the annotation (same as left for markers)
+	span  bool       // Whether the annotation is drawn as a span
+	c     color.RGBA // Color of the annotation
+	label *label     // Label for the annotation, if there is room for one
+}
+
+// Lay out a set of annotations on a time axis of the specified width. Labels
+// are set along the top of the visualization, staggered down across rows where
+// they would otherwise overlap.
+func annotationLayout(
+	w int,
+	annotations []Annotation,
+	tA float64,
+	tτ float64,
+	t *Theme) []annotationMark {
+
+	sorted := make([]Annotation, len(annotations))
+	copy(sorted, annotations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time < sorted[j].Time
+	})
+
+	var marks []annotationMark
+	var rowEnds []int
+	for _, a := range sorted {
+		m := annotationMark{
+			x0:   int(float64(w) * (float64(a.Time) - tA) / tτ),
+			span: a.End > a.Time,
+			c:    t.Text}
+		m.x1 = m.x0
+		if m.span {
+			m.x1 = int(float64(w) * (float64(a.End) - tA) / tτ)
+		}
+		if m.x1 < 0 || m.x0 >= w {
+			continue
+		}
+		m.x0, m.x1 = intMax(m.x0, -1), intMin(m.x1, w)
+		if c, ok := parseHexColor(a.Color); ok {
+			m.c = c
+		}
+
+		// Find the first row in which the label will clear the label before it.
+		x := intMax(m.x0, 0) + labelMargin
+		for row := 0; row < annotationRows && a.Label != ""; row++ {
+			if row == len(rowEnds) {
+				rowEnds = append(rowEnds, 0)
+			}
+			if rowEnds[row] <= x {
+				y := labelMargin + row*(glyphHeight+3)
+				m.label = &label{x, y, a.Label}
+				rowEnds[row] = x + (len([]rune(a.Label))+1)*glyphAdvance
+				break
+			}
+		}
+		marks = append(marks, m)
+	}
+	return marks
+}
+
+// Utility function to draw annotations onto a visualization, with spans shaded
+// translucently in their annotation's color and bordered by solid lines.
+func drawAnnotations(vis *image.RGBA, marks []annotationMark, t *Theme) {
+	w, h := vis.Bounds().Max.X, vis.Bounds().Max.Y
+	for _, m := range marks {
+		if m.span {
+			for y := 0; y < h; y++ {
+				for x := intMax(m.x0+1, 0); x < intMin(m.x1, w); x++ {
+					c := getRGBA(vis, x, y)
+					*c = blend(*c, m.c, spanOpacity)
+				}
+			}
+		}
+		for y := 0; y < h; y++ {
+			*getRGBA(vis, m.x0, y) = m.c
+			*getRGBA(vis, m.x1, y) = m.c
+		}
+	}
+	for _, m := range marks {
+		if m.label != nil {
+			l := m.label
+			drawText(vis, l.x, l.y, l.text, m.c, t.Background)
+		}
+	}
+}
+
+// Utility function to parse a color given as a "#rrggbb" or "#rgb" hex string.
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) == 4 && s[0] == '#' {
+		s = string([]byte{'#', s[1], s[1], s[2], s[2], s[3], s[3]})
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	rgb, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	r, g, b := uint8(rgb>>16), uint8(rgb>>8), uint8(rgb)
+	return color.RGBA{r, g, b, opaque}, true
+}
@@ -0,0 +1,373 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Opacity of the fill of the boxes and violins, over the background.
+const boxFillOpacity = 0.4
+
+// Box-plot visualization of run times, with a column for each event type or
+// region seen, and run times on a log2 scale up the y-axis. Each box spans the
+// interquartile range of its group's run times, with a line across it at the
+// median, and whiskers out to the most extreme run times within 1.5 times the
+// interquartile range of the box, as measured on the log2 scale the run times
+// are drawn on. Run times beyond the whiskers are counted as outliers, and
+// their counts are set above and below the whiskers. Drawn as violins, the
+// boxes are narrowed to a bar within an outline of the density of the run
+// times. Events of every status are included, so the status filter selects
+// which are drawn; in-progress events are drawn by their run times so far.
+type boxPlot struct {
+	w        int               // Width of the visualization
+	h        int               // Height of the visualization
+	theme    *Theme            // Colors to render the visualization with
+	yLog2    float64           // Pixels over which elapsed times double
+	tMin     float64           // Run time at the origin of the run-time axis
+	byRegion bool              // Whether to group events by region, not type
+	violin   bool              // Whether to draw violins rather than boxes
+	sketches []*quantileSketch // Run-time sketches by type or region
+	labels   bool              // Whether to draw axis labels
+	jitter   *Jitter           // Source of random noise applied to run times
+}
+
+// Summary statistics of the run times in a group, as drawn on a box plot.
+type boxStats struct {
+	q1     float64 // First quartile of the run times
+	median float64 // Median of the run times
+	q3     float64 // Third quartile of the run times
+	lo     float64 // End of the lower whisker
+	hi     float64 // End of the upper whisker
+	below  uint64  // Count of outliers below the lower whisker
+	above  uint64  // Count of outliers above the upper whisker
+}
+
+// NewBoxPlot returns a run-time box-plot-visualization generator, grouping
+// events by type or, if byRegion is set, by region, and drawing each group as
+// a violin rather than a box if violin is set.
+func NewBoxPlot(
+	width int,
+	height int,
+	theme *Theme,
+	yLog2 float64,
+	minRunTime float64,
+	byRegion bool,
+	violin bool,
+	labels bool,
+	jitter *Jitter) Visualizer {
+
+	return &boxPlot{
+		width,
+		height,
+		themeOrDefault(theme),
+		yLog2,
+		minRunTime,
+		byRegion,
+		violin,
+		make([]*quantileSketch, categoryValues),
+		labels,
+		jitter}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *boxPlot) Record(e *EventData) {
+
+	// Apply a bit of random "noise" to the time scale, as is done for the
+	// histogram visualization, to avoid quantization artifacts in the run
+	// times of short-lived events, and floor run times at the origin of the
+	// log2 scale as it does.
+	t := math.Max(v.tMin, e.RunTime()+v.jitter.offset(e))
+
+	g := int(e.Type)
+	if v.byRegion {
+		g = int(e.Region)
+	}
+	if v.sketches[g] == nil {
+		v.sketches[g] = &quantileSketch{}
+	}
+	v.sketches[g].add(t)
+}
+
+// Clone returns an empty box-plot-visualization generator with the same
+// configuration as this one.
+func (v *boxPlot) Clone() Visualizer {
+	c := *v
+	c.sketches = make([]*quantileSketch, categoryValues)
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *boxPlot) Merge(o Visualizer) {
+	other := o.(*boxPlot)
+	for g, q := range other.sketches {
+		if q == nil {
+			continue
+		}
+		if v.sketches[g] == nil {
+			v.sketches[g] = &quantileSketch{}
+		}
+		v.sketches[g].merge(q)
+	}
+}
+
+// Data returns the count of run times in each group and the summary statistics
+// drawn for it, with the groups in the order they are drawn. Run times are
+// floored at the origin of the run-time axis, as they are when drawn.
+func (v *boxPlot) Data() *SeriesData {
+	groups := v.groups()
+	n := len(groups)
+	x, count := make([]float64, n), make([]float64, n)
+	q1, median, q3 := make([]float64, n), make([]float64, n), make([]float64, n)
+	lo, hi := make([]float64, n), make([]float64, n)
+	below, above := make([]float64, n), make([]float64, n)
+	for i, g := range groups {
+		q := v.sketches[g]
+		s := boxStatsOf(q, v.tMin)
+		x[i], count[i] = float64(g), float64(q.n)
+		q1[i], median[i], q3[i] = s.q1, s.median, s.q3
+		lo[i], hi[i] = s.lo, s.hi
+		below[i], above[i] = float64(s.below), float64(s.above)
+	}
+	axis := TypeAxis
+	if v.byRegion {
+		axis = RegionAxis
+	}
+	return &SeriesData{
+		XAxis: axis,
+		X:     x,
+		Series: map[string][]float64{
+			"count":          count,
+			"q1":             q1,
+			"median":         median,
+			"q3":             q3,
+			"whisker-low":    lo,
+			"whisker-high":   hi,
+			"outliers-below": below,
+			"outliers-above": above}}
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *boxPlot) Render() image.Image {
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	for y := float64(v.bottom()) - v.yLog2; y > 0; y -= v.yLog2 {
+		drawYGridLine(vis, int(y), v.theme.Grid)
+	}
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					*getRGBA(vis, x, y) = c
+				}
+			}
+		},
+		func(labels []label) {
+			drawLabels(vis, labels, v.theme)
+		})
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *boxPlot) RenderSVG(out io.Writer) error {
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	for y := float64(v.bottom()) - v.yLog2; y > 0; y -= v.yLog2 {
+		svg.yGridLine(int(y))
+	}
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			svg.rect(
+				float64(r.Min.X), float64(r.Min.Y),
+				float64(r.Dx()), float64(r.Dy()), c)
+		},
+		svg.labels)
+	return svg.close()
+}
+
+// Lay out the chart, drawing it with the specified functions for filling a
+// rectangle and for drawing labels.
+func (v *boxPlot) draw(
+	fill func(image.Rectangle, color.RGBA),
+	text func([]label)) {
+
+	groups := v.groups()
+	if len(groups) == 0 {
+		return
+	}
+
+	// Leave room on the left for the run-time labels, with the columns for
+	// the groups filling the rest of the visualization.
+	x0 := 0
+	if v.labels {
+		x0 = 2*labelMargin + 4*glyphAdvance
+	}
+	colW := intMax(1, (v.w-x0)/len(groups))
+	fillColor := blend(v.theme.Background, v.theme.Success, boxFillOpacity)
+
+	var labels []label
+	for i, g := range groups {
+		q := v.sketches[g]
+		s := boxStatsOf(q, v.tMin)
+		xc := x0 + i*colW + colW/2
+		yHi, yLo := v.y(s.hi), v.y(s.lo)
+
+		// Draw the violin outline or the box, then the whiskers and median.
+		half := intMax(1, intMin(colW*3/10, 4*glyphHeight))
+		if v.violin {
+			density := v.density(q)
+			peak := 0.0
+			for _, d := range density {
+				peak = math.Max(peak, d)
+			}
+			for y, d := range density {
+				dx := int(d/peak*float64(colW*2/5) + 0.5)
+				if dx > 0 {
+					fill(image.Rect(xc-dx, y, xc+dx+1, y+1), fillColor)
+				}
+			}
+			half = 1
+		}
+		fill(image.Rect(xc, yHi, xc+1, yLo+1), v.theme.Success)
+		fill(image.Rect(xc-half/2, yHi, xc+half/2+1, yHi+1), v.theme.Success)
+		fill(image.Rect(xc-half/2, yLo, xc+half/2+1, yLo+1), v.theme.Success)
+		box := image.Rect(xc-half, v.y(s.q3), xc+half+1, v.y(s.q1)+1)
+		fill(box, v.theme.Success)
+		if !v.violin {
+			fill(box.Inset(1), fillColor)
+		}
+		yMed, medHalf := v.y(s.median), intMax(half, 3)
+		fill(image.Rect(xc-medHalf, yMed-1, xc+medHalf+1, yMed+1), v.theme.Text)
+
+		// Count the outliers beyond each whisker.
+		if s.above > 0 {
+			n := fmt.Sprint(s.above)
+			labels = append(
+				labels,
+				label{xc - len(n)*glyphAdvance/2, yHi - glyphHeight - 3, n})
+		}
+		if s.below > 0 {
+			n := fmt.Sprint(s.below)
+			labels = append(
+				labels, label{xc - len(n)*glyphAdvance/2, yLo + 3, n})
+		}
+
+		// Name the group below its column, abbreviated if the name would not
+		// otherwise fit.
+		if v.labels {
+			name := v.groupName(g, colW)
+			labels = append(
+				labels,
+				label{xc - len(name)*glyphAdvance/2,
+					v.h - labelMargin - glyphHeight, name})
+		}
+	}
+	if v.labels {
+		labels = append(labels, runTimeLabels(v.bottom(), v.yLog2, v.tMin)...)
+	}
+	text(labels)
+}
+
+// Get the event types or regions with any recorded run times, in the order in
+// which they are drawn.
+func (v *boxPlot) groups() []int {
+	var groups []int
+	for g, q := range v.sketches {
+		if q != nil {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// Get the y-position of the bottom of the run-time axis, leaving room below it
+// for the group names if labels are drawn.
+func (v *boxPlot) bottom() int {
+	if v.labels {
+		return v.h - glyphHeight - 2*labelMargin - 1
+	}
+	return v.h
+}
+
+// Get the y-position for the specified run time.
+func (v *boxPlot) y(t float64) int {
+	return v.bottom() - int(v.yLog2*runTimeLog2(math.Max(v.tMin, t), v.tMin))
+}
+
+// Get the density of the run times in a sketch at each y-position from the top
+// of the visualization down to the bottom of the run-time axis, spreading the
+// count of each sketch bucket evenly over the rows it spans.
+func (v *boxPlot) density(q *quantileSketch) []float64 {
+	bottom, origin := v.bottom(), math.Log2(v.tMin)
+	d := make([]float64, bottom+1)
+	for i, c := range q.counts {
+		if c == 0 {
+			continue
+		}
+
+		// Find the range of heights above the bottom of the axis spanned by
+		// the bucket. Run times at or below the origin of the axis are floored
+		// there as they are recorded, so the buckets below it should be empty,
+		// but they are otherwise spread over the span of the bucket just
+		// above.
+		p0 := math.Max(0, sketchMinLog2+float64(i-1)/sketchSteps-origin)
+		p1 := math.Max(0, sketchMinLog2+float64(i)/sketchSteps-origin)
+		h0, h1 := p0*v.yLog2, p1*v.yLog2
+		if i == 0 || h1 <= h0 {
+			h0, h1 = 0, v.yLog2/sketchSteps
+		}
+		for r := int(h0); float64(r) < h1 && r <= bottom; r++ {
+			overlap := math.Min(h1, float64(r+1)) - math.Max(h0, float64(r))
+			d[bottom-r] += float64(c) * overlap / (h1 - h0)
+		}
+	}
+	return d
+}
+
+// Get the name of a group, abbreviated if it would not fit in a column of the
+// specified width.
+func (v *boxPlot) groupName(g int, colW int) string {
+	prefix := "type"
+	if v.byRegion {
+		prefix = "region"
+	}
+	name := fmt.Sprintf("%s %d", prefix, g)
+	if len(name)*glyphAdvance >= colW {
+		name = fmt.Sprintf("%c%d", prefix[0], g)
+	}
+	return name
+}
+
+// Get the summary statistics of the run times in a sketch, with run times
+// floored at the specified run time at the origin of the log2 scale.
+func boxStatsOf(q *quantileSketch, tMin float64) boxStats {
+	s := boxStats{
+		q1:     q.quantile(0.25),
+		median: q.quantile(0.5),
+		q3:     q.quantile(0.75)}
+	p1, p3 := math.Log2(math.Max(tMin, s.q1)), math.Log2(math.Max(tMin, s.q3))
+	iqr := p3 - p1
+	s.lo, s.hi, s.below, s.above = q.extent(
+		math.Exp2(p1-1.5*iqr), math.Exp2(p3+1.5*iqr))
+	return s
+}
@@ -0,0 +1,427 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// Number of distinct values of the event type and region fields.
+const categoryValues = 256
+
+// SuccessRateGroup is the success rate of the completed events of an event
+// type, a region, or a pairing of both. Type or Region is -1 where events were
+// not grouped by that field, and the rate is a percentage.
+type SuccessRateGroup struct {
+	Type    int     `json:"type"`
+	Region  int     `json:"region"`
+	Success int     `json:"success"`
+	Failure int     `json:"failure"`
+	Rate    float64 `json:"rate"`
+}
+
+// SuccessRateBreakdown is implemented by visualization generators which break
+// the success rate of the events recorded into them down by event type and
+// region. Groups returns the groups with any completed events, in the order
+// they are drawn.
+type SuccessRateBreakdown interface {
+	Visualizer
+	Groups() []SuccessRateGroup
+}
+
+// Categorical bar chart of success rates. Grouped by event type or by region
+// alone, each group is drawn as a bar split between the success and failure
+// colors in proportion to its success rate, and labelled with the rate and the
+// count of completed events. Grouped by both, the groups are drawn as a grid
+// with a row for each event type and a column for each region, with each cell
+// shaded from the failure color to the success color by its success rate.
+// Group names and values are always drawn, since the chart means nothing
+// without them; the labels setting only controls the legend.
+type breakdown struct {
+	w        int    // Width of the visualization
+	h        int    // Height of the visualization
+	theme    *Theme // Colors to render the visualization with
+	byType   bool   // Whether to group events by type
+	byRegion bool   // Whether to group events by region
+	byVolume bool   // Whether to sort by event count rather than by rate
+	pass     []int  // Counts of successful events by type and region
+	fail     []int  // Counts of failed events by type and region
+	labels   bool   // Whether to draw a legend
+}
+
+// NewSuccessRateBreakdown returns a success-rate-breakdown-visualization
+// generator, grouping events by type, by region, or by both. If neither is
+// selected, events are grouped by type. Groups are sorted from the lowest
+// success rate to the highest, or from the most events to the fewest if
+// byVolume is set.
+func NewSuccessRateBreakdown(
+	width int,
+	height int,
+	theme *Theme,
+	byType bool,
+	byRegion bool,
+	byVolume bool,
+	labels bool) Visualizer {
+
+	return &breakdown{
+		width,
+		height,
+		themeOrDefault(theme),
+		byType || !byRegion,
+		byRegion,
+		byVolume,
+		make([]int, categoryValues*categoryValues),
+		make([]int, categoryValues*categoryValues),
+		labels}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *breakdown) Record(e *EventData) {
+	i := int(e.Type)*categoryValues + int(e.Region)
+	if e.Status == 0 {
+		v.pass[i]++
+	} else if e.Status > 0 {
+		v.fail[i]++
+	}
+}
+
+// Clone returns an empty success-rate-breakdown-visualization generator with
+// the same configuration as this one.
+func (v *breakdown) Clone() Visualizer {
+	c := *v
+	c.pass = make([]int, len(v.pass))
+	c.fail = make([]int, len(v.fail))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *breakdown) Merge(o Visualizer) {
+	other := o.(*breakdown)
+	addInts(v.pass, other.pass)
+	addInts(v.fail, other.fail)
+}
+
+// Groups returns the success rate of each group with any completed events, in
+// the order they are drawn.
+func (v *breakdown) Groups() []SuccessRateGroup {
+	return v.groups(v.byType, v.byRegion)
+}
+
+// Data returns the counts of successful and failed events and the success rate
+// of each group, in the order they are drawn. Grouped by both event type and
+// region, the groups are exported as grids, with a row for each type and a
+// column for each region, and cells for pairings with no completed events
+// holding zero.
+func (v *breakdown) Data() *SeriesData {
+	if !v.byType || !v.byRegion {
+		groups := v.Groups()
+		x, rate := make([]float64, len(groups)), make([]float64, len(groups))
+		pass, fail := make([]int, len(groups)), make([]int, len(groups))
+		axis := TypeAxis
+		if !v.byType {
+			axis = RegionAxis
+		}
+		for i, g := range groups {
+			x[i] = float64(g.Type)
+			if !v.byType {
+				x[i] = float64(g.Region)
+			}
+			pass[i], fail[i], rate[i] = g.Success, g.Failure, g.Rate
+		}
+		return &SeriesData{
+			XAxis: axis,
+			X:     x,
+			Series: map[string][]float64{
+				"success": float64s(pass),
+				"failure": float64s(fail),
+				"rate":    rate}}
+	}
+
+	types, regions := v.groups(true, false), v.groups(false, true)
+	x, y := make([]float64, len(regions)), make([]float64, len(types))
+	row, col := make(map[int]int), make(map[int]int)
+	for i, g := range types {
+		y[i] = float64(g.Type)
+		row[g.Type] = i
+	}
+	for i, g := range regions {
+		x[i] = float64(g.Region)
+		col[g.Region] = i
+	}
+	pass, fail, rate := make([][]float64, len(types)),
+		make([][]float64, len(types)), make([][]float64, len(types))
+	for i := range types {
+		pass[i] = make([]float64, len(regions))
+		fail[i] = make([]float64, len(regions))
+		rate[i] = make([]float64, len(regions))
+	}
+	for _, g := range v.Groups() {
+		i, j := row[g.Type], col[g.Region]
+		pass[i][j] = float64(g.Success)
+		fail[i][j] = float64(g.Failure)
+		rate[i][j] = g.Rate
+	}
+	return &SeriesData{
+		XAxis: RegionAxis,
+		X:     x,
+		YAxis: TypeAxis,
+		Y:     y,
+		Grids: map[string][][]float64{
+			"success": pass,
+			"failure": fail,
+			"rate":    rate}}
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *breakdown) Render() image.Image {
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					*getRGBA(vis, x, y) = c
+				}
+			}
+		},
+		func(labels []label) {
+			drawLabels(vis, labels, v.theme)
+		})
+	if v.labels {
+		drawLegend(vis, v.legend(), v.theme)
+	}
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *breakdown) RenderSVG(out io.Writer) error {
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			svg.rect(
+				float64(r.Min.X), float64(r.Min.Y),
+				float64(r.Dx()), float64(r.Dy()), c)
+		},
+		svg.labels)
+	if v.labels {
+		svg.legend(v.legend())
+	}
+	return svg.close()
+}
+
+// Lay out the chart, drawing it with the specified functions for filling a
+// rectangle and for drawing labels.
+func (v *breakdown) draw(
+	fill func(image.Rectangle, color.RGBA),
+	text func([]label)) {
+
+	// Leave room at the top for the legend.
+	top := labelMargin
+	if v.labels {
+		top += len(v.legend()) * (glyphHeight + 3)
+	}
+
+	if v.byType && v.byRegion {
+		v.drawGrid(top, fill, text)
+	} else {
+		v.drawBars(top, fill, text)
+	}
+}
+
+// Draw a bar for each group, from the top of the chart down, for as many
+// groups as there is room for.
+func (v *breakdown) drawBars(
+	top int,
+	fill func(image.Rectangle, color.RGBA),
+	text func([]label)) {
+
+	groups := v.Groups()
+	if len(groups) == 0 {
+		return
+	}
+
+	names := make([]string, len(groups))
+	nameLen := 0
+	for i, g := range groups {
+		names[i] = v.groupName(g)
+		nameLen = intMax(nameLen, len(names[i]))
+	}
+	x0 := 2*labelMargin + nameLen*glyphAdvance
+	pitch := intMax(glyphHeight+5, intMin((v.h-top)/len(groups), 4*glyphHeight))
+
+	var labels []label
+	for i, g := range groups {
+		y := top + i*pitch
+		if y+pitch > v.h {
+			break
+		}
+		textY := y + (pitch-glyphHeight)/2
+		bar := image.Rect(x0, y+1, v.w-labelMargin, y+pitch-1)
+		split := bar.Min.X + int(float64(bar.Dx())*g.Rate/100+0.5)
+		pass, fail := bar, bar
+		pass.Max.X, fail.Min.X = split, split
+		fill(pass, v.theme.Success)
+		fill(fail, v.theme.Failure)
+		labels = append(
+			labels,
+			label{labelMargin, textY, names[i]},
+			label{x0 + labelMargin, textY, fmt.Sprintf(
+				"%.3f%% of %d", g.Rate, g.Success+g.Failure)})
+	}
+	text(labels)
+}
+
+// Draw a cell for each pairing of event type and region, with a row for each
+// type and a column for each region.
+func (v *breakdown) drawGrid(
+	top int,
+	fill func(image.Rectangle, color.RGBA),
+	text func([]label)) {
+
+	types, regions := v.groups(true, false), v.groups(false, true)
+	if len(types) == 0 {
+		return
+	}
+	row, col := make(map[int]int), make(map[int]int)
+	for i, g := range types {
+		row[g.Type] = i
+	}
+	for i, g := range regions {
+		col[g.Region] = i
+	}
+
+	// Row labels are set down the left edge, and column labels above the
+	// columns, with the grid filling the rest of the visualization.
+	nameLen := len(fmt.Sprintf("T%d", categoryValues-1))
+	x0 := 2*labelMargin + nameLen*glyphAdvance
+	y0 := top + glyphHeight + 3
+	cellW := intMax(1, (v.w-labelMargin-x0)/len(regions))
+	cellH := intMax(1, (v.h-y0)/len(types))
+
+	var labels []label
+	for i, g := range types {
+		y := y0 + i*cellH + (cellH-glyphHeight)/2
+		labels = append(labels, label{labelMargin, y, v.groupName(g)})
+	}
+	for i, g := range regions {
+		x := x0 + i*cellW + labelMargin
+		labels = append(labels, label{x, top, v.groupName(g)})
+	}
+
+	for _, g := range v.Groups() {
+		x, y := x0+col[g.Region]*cellW, y0+row[g.Type]*cellH
+		fill(
+			image.Rect(x+1, y+1, x+cellW, y+cellH),
+			blend(v.theme.Failure, v.theme.Success, g.Rate/100))
+
+		// Label the cell with its rate, and its count as well if there is room
+		// for a second line of text.
+		lines := []string{fmt.Sprintf("%.1f%%", g.Rate)}
+		if cellH >= 2*(glyphHeight+3)+2 {
+			lines = append(lines, fmt.Sprintf("%d", g.Success+g.Failure))
+		}
+		for j, line := range lines {
+			if len(line)*glyphAdvance+2*labelMargin <= cellW &&
+				glyphHeight+2*labelMargin <= cellH {
+
+				labels = append(
+					labels,
+					label{x + labelMargin + 1, y + labelMargin + 1 +
+						j*(glyphHeight+3), line})
+			}
+		}
+	}
+	text(labels)
+}
+
+// Get the legend, which shows the colors of a success rate of 100% and 0%.
+func (v *breakdown) legend() []legendEntry {
+	return []legendEntry{
+		{v.theme.Success, "success"},
+		{v.theme.Failure, "failure"}}
+}
+
+// Get the name of a group, as labelled on the chart. Names are abbreviated in
+// the grid, where they label its rows and columns.
+func (v *breakdown) groupName(g SuccessRateGroup) string {
+	grid := v.byType && v.byRegion
+	switch {
+	case g.Type >= 0 && grid:
+		return fmt.Sprintf("T%d", g.Type)
+	case g.Region >= 0 && grid:
+		return fmt.Sprintf("R%d", g.Region)
+	case g.Type >= 0:
+		return fmt.Sprintf("type %d", g.Type)
+	default:
+		return fmt.Sprintf("region %d", g.Region)
+	}
+}
+
+// Get the success rate of each group with any completed events, grouping by
+// the specified fields, in the configured order.
+func (v *breakdown) groups(byType bool, byRegion bool) []SuccessRateGroup {
+	index := make(map[[2]int]int)
+	var groups []SuccessRateGroup
+	for i := range v.pass {
+		if v.pass[i]+v.fail[i] == 0 {
+			continue
+		}
+		key := [2]int{-1, -1}
+		if byType {
+			key[0] = i / categoryValues
+		}
+		if byRegion {
+			key[1] = i % categoryValues
+		}
+		j, exists := index[key]
+		if !exists {
+			j = len(groups)
+			index[key] = j
+			groups = append(
+				groups, SuccessRateGroup{Type: key[0], Region: key[1]})
+		}
+		groups[j].Success += v.pass[i]
+		groups[j].Failure += v.fail[i]
+	}
+	for i := range groups {
+		g := &groups[i]
+		g.Rate = 100 * float64(g.Success) / float64(g.Success+g.Failure)
+	}
+
+	// Ties are broken by type and then by region, so the order is stable.
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		nA, nB := a.Success+a.Failure, b.Success+b.Failure
+		switch {
+		case v.byVolume && nA != nB:
+			return nA > nB
+		case !v.byVolume && a.Rate != b.Rate:
+			return a.Rate < b.Rate
+		case a.Type != b.Type:
+			return a.Type < b.Type
+		default:
+			return a.Region < b.Region
+		}
+	})
+	return groups
+}
@@ -0,0 +1,297 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+	"time"
+)
+
+// Calendar heatmap of daily event outcomes, with a cell for each day of the
+// time range, a column for each week, and a row for each day of the week from
+// Sunday at the top to Saturday at the bottom. Each cell is shaded from the
+// failure color to the success color by the day's success rate or, if shading
+// by failures, from the grid color to the failure color by the day's count of
+// failures relative to the day with the most. Days without any events counted
+// are left in the grid color. Days begin at midnight in the configured time
+// zone, so they can be matched to local business days, and may be more or less
+// than 24 hours long where they cross a daylight-saving transition.
+type calendar struct {
+	w          int            // Width of the visualization
+	h          int            // Height of the visualization
+	theme      *Theme         // Colors to render the visualization with
+	location   *time.Location // Time zone in which days are reckoned
+	days       []int64        // Start time of each day, and end of the last
+	weekday    int            // Day of the week of the first day, from Sunday
+	byFailures bool           // Whether to shade days by their failure counts
+	pass       []int          // Counts of successful events by day
+	fail       []int          // Counts of failed events by day
+	labels     bool           // Whether to draw axis labels and a legend
+}
+
+// NewCalendar returns a calendar-heatmap-visualization generator, with a cell
+// for each day in the specified time zone which overlaps the time range, or
+// in UTC if no time zone is specified. Only events starting within the time
+// range are counted, so the first and last days may be partial.
+func NewCalendar(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	location *time.Location,
+	byFailures bool,
+	labels bool) Visualizer {
+
+	if location == nil {
+		location = time.UTC
+	}
+
+	// Find the start of each day from midnight on the first day of the range
+	// until the range has been covered, always including at least one day.
+	// Each midnight is found from the calendar date rather than by adding a
+	// fixed number of seconds, to allow for daylight-saving transitions.
+	t0 := time.Unix(int64(minTime), 0).In(location)
+	y, m, d := t0.Date()
+	var days []int64
+	for i := 0; ; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, location).Unix()
+		days = append(days, day)
+		if i > 0 && day >= int64(maxTime) {
+			break
+		}
+	}
+
+	return &calendar{
+		width,
+		height,
+		themeOrDefault(theme),
+		location,
+		days,
+		int(t0.Weekday()),
+		byFailures,
+		make([]int, len(days)-1),
+		make([]int, len(days)-1),
+		labels}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *calendar) Record(e *EventData) {
+	t := e.Start
+	if e.Status < 0 || t < v.days[0] || t >= v.days[len(v.days)-1] {
+		return
+	}
+	day := sort.Search(len(v.days), func(i int) bool { return v.days[i] > t })
+	if e.Status == 0 {
+		v.pass[day-1]++
+	} else {
+		v.fail[day-1]++
+	}
+}
+
+// Clone returns an empty calendar-heatmap-visualization generator with the
+// same configuration as this one.
+func (v *calendar) Clone() Visualizer {
+	c := *v
+	c.pass = make([]int, len(v.pass))
+	c.fail = make([]int, len(v.fail))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *calendar) Merge(o Visualizer) {
+	other := o.(*calendar)
+	addInts(v.pass, other.pass)
+	addInts(v.fail, other.fail)
+}
+
+// Data returns the counts of successful and failed events on each day, along
+// with each day's success rate as a percentage, which is zero for days without
+// any completed events.
+func (v *calendar) Data() *SeriesData {
+	n := len(v.pass)
+	x, rate := make([]float64, n), make([]float64, n)
+	for day := range x {
+		x[day] = float64(v.days[day])
+		if total := v.pass[day] + v.fail[day]; total > 0 {
+			rate[day] = 100 * float64(v.pass[day]) / float64(total)
+		}
+	}
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     x,
+		Series: map[string][]float64{
+			"success": float64s(v.pass),
+			"failure": float64s(v.fail),
+			"rate":    rate}}
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *calendar) Render() image.Image {
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					*getRGBA(vis, x, y) = c
+				}
+			}
+		},
+		func(labels []label) {
+			drawLabels(vis, labels, v.theme)
+		})
+	if v.labels {
+		drawLegend(vis, v.legend(), v.theme)
+	}
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *calendar) RenderSVG(out io.Writer) error {
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	v.draw(
+		func(r image.Rectangle, c color.RGBA) {
+			svg.rect(
+				float64(r.Min.X), float64(r.Min.Y),
+				float64(r.Dx()), float64(r.Dy()), c)
+		},
+		svg.labels)
+	if v.labels {
+		svg.legend(v.legend())
+	}
+	return svg.close()
+}
+
+// Lay out the calendar, drawing it with the specified functions for filling a
+// rectangle and for drawing labels. Cells are square, sized to fit all of the
+// weeks into the visualization, with a one-pixel gap between them unless they
+// are too small to spare one.
+func (v *calendar) draw(
+	fill func(image.Rectangle, color.RGBA),
+	text func([]label)) {
+
+	// Leave room for the legend and the month labels above the calendar, and
+	// for the day-of-the-week labels to its left.
+	x0, y0 := 0, 0
+	if v.labels {
+		x0 = 2*labelMargin + 3*glyphAdvance
+		y0 = labelMargin + (len(v.legend())+1)*(glyphHeight+3)
+	}
+	days := len(v.pass)
+	weeks := (v.weekday + days + 6) / 7
+	cell := intMax(1, intMin((v.w-x0-labelMargin)/weeks, (v.h-y0)/7))
+
+	size := intMax(1, cell-1)
+	maxFail := v.maxFailures()
+	for day := 0; day < days; day++ {
+		x := x0 + (v.weekday+day)/7*cell
+		y := y0 + (v.weekday+day)%7*cell
+		fill(
+			image.Rect(x, y, x+size, y+size),
+			v.dayColor(v.pass[day], v.fail[day], maxFail))
+	}
+
+	if v.labels {
+		text(append(v.monthLabels(x0, y0, cell), v.weekdayLabels(y0, cell)...))
+	}
+}
+
+// Get the color of a day's cell.
+func (v *calendar) dayColor(pass int, fail int, maxFail int) color.RGBA {
+	switch {
+	case pass+fail == 0:
+		return v.theme.Grid
+	case v.byFailures && fail == 0:
+		return v.theme.Grid
+	case v.byFailures:
+		return blend(
+			v.theme.Grid, v.theme.Failure, float64(fail)/float64(maxFail))
+	default:
+		return blend(
+			v.theme.Failure,
+			v.theme.Success,
+			float64(pass)/float64(pass+fail))
+	}
+}
+
+// Get the labels marking the column in which each month begins, dropping any
+// which would overlap the label before them. Months are labelled by name,
+// except for January, which is labelled with its year.
+func (v *calendar) monthLabels(x0 int, y0 int, cell int) []label {
+	var labels []label
+	end := 0
+	for day := range v.pass {
+		t := time.Unix(v.days[day], 0).In(v.location)
+		if day > 0 && t.Day() != 1 {
+			continue
+		}
+		text := t.Format("Jan")
+		if t.Month() == time.January {
+			text = t.Format("2006")
+		}
+		x := x0 + (v.weekday+day)/7*cell
+		if x < end {
+			continue
+		}
+		labels = append(labels, label{x, y0 - glyphHeight - 3, text})
+		end = x + (len(text)+1)*glyphAdvance
+	}
+	return labels
+}
+
+// Get the labels for the rows of Mondays, Wednesdays, and Fridays, if the
+// cells are large enough for labels on alternate rows to fit.
+func (v *calendar) weekdayLabels(y0 int, cell int) []label {
+	if 2*cell < glyphHeight+3 {
+		return nil
+	}
+	var labels []label
+	for _, d := range []time.Weekday{time.Monday, time.Wednesday, time.Friday} {
+		y := y0 + int(d)*cell + (cell-1-glyphHeight)/2
+		labels = append(labels, label{labelMargin, y, d.String()[:3]})
+	}
+	return labels
+}
+
+// Get the legend for the configured shading of the cells.
+func (v *calendar) legend() []legendEntry {
+	if v.byFailures {
+		return []legendEntry{
+			{v.theme.Failure, fmt.Sprintf("%d failures", v.maxFailures())},
+			{v.theme.Grid, "no failures"}}
+	}
+	return []legendEntry{
+		{v.theme.Success, "success"},
+		{v.theme.Failure, "failure"},
+		{v.theme.Grid, "no events"}}
+}
+
+// Get the most failures counted on any one day.
+func (v *calendar) maxFailures() int {
+	maxFail := 0
+	for _, n := range v.fail {
+		maxFail = intMax(maxFail, n)
+	}
+	return maxFail
+}
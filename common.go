@@ -21,12 +21,12 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 	"math"
 	"unsafe"
 )
 
 const (
-	grid      = 50    // Gray level for grid lines
 	opaque    = 255   // Alpha component of an opaque color value
 	saturated = 255   // Saturated 8-bit color value
 	maxC16    = 65535 // Maximum color value returned from image.RGBA.At()
@@ -46,16 +46,25 @@ var pointConvolutionKernel = [25]float64{
 	0.000004, 0.000455, 0.001978, 0.000455, 0.000004,
 }
 
+// Number of units of EventData run time in a second.
+const RunTimeUnitsPerSecond = 1000000
+
 // Struct to represent data to submit to the visualization generators, and to be
-// used for the binary log format.
+// used for the binary log format. Fields are ordered so that the struct has no
+// padding, and is laid out in memory exactly as it is in a binary log.
 type EventData struct {
 	ID       int32 // Event identifier.
-	Start    int32 // In seconds since the beginning of the Unix epoch.
-	Run      int32 // Event run time, in seconds.
 	Type     uint8 // Event type indication.
 	Status   int8  // 0 for success, >0 for failure, <0 for in-progress.
 	Region   uint8 // Region identifier, 0 if undefined.
 	Progress uint8 // Event progress percentage.
+	Start    int64 // In seconds since the beginning of the Unix epoch.
+	Run      int64 // Event run time, in microseconds.
+}
+
+// RunTime returns the run time of the event in seconds.
+func (e *EventData) RunTime() float64 {
+	return float64(e.Run) / RunTimeUnitsPerSecond
 }
 
 // Abstract interface for visualization generators.
@@ -64,9 +73,34 @@ type Visualizer interface {
 	Render() image.Image
 }
 
+// Optional interface for visualization generators which can also render their
+// output as a scalable vector graphic, for embedding in documents where a
+// raster image would not scale cleanly.
+type VectorVisualizer interface {
+	Visualizer
+	RenderSVG(io.Writer) error
+}
+
+// Optional interface for visualization generators which can also render their
+// output as text, for display in a terminal where no image viewer is available.
+type TextVisualizer interface {
+	Visualizer
+	RenderText(io.Writer) error
+}
+
+// Optional interface for visualization generators whose recorded data can be
+// split across several independent accumulators and then recombined, so that
+// events can be recorded in parallel. Clone returns an empty generator with
+// the same configuration as the original, and Merge folds the data recorded by
+// such a clone into the generator it is called on.
+type Mergeable interface {
+	Visualizer
+	Clone() Visualizer
+	Merge(Visualizer)
+}
+
 // Utility function to draw a vertical grid line at the specified x position.
-func drawXGridLine(vis *image.RGBA, x int) {
-	c := color.RGBA{grid, grid, grid, opaque}
+func drawXGridLine(vis *image.RGBA, x int, c color.RGBA) {
 	h := vis.Bounds().Max.Y
 	for y := 0; y < h; y++ {
 		vis.Set(x, y, c)
@@ -74,8 +108,7 @@ func drawXGridLine(vis *image.RGBA, x int) {
 }
 
 // Utility function to draw a horizontal grid line as the specified y position.
-func drawYGridLine(vis *image.RGBA, y int) {
-	c := color.RGBA{grid, grid, grid, opaque}
+func drawYGridLine(vis *image.RGBA, y int, c color.RGBA) {
 	w := vis.Bounds().Max.X
 	for x := 0; x < w; x++ {
 		vis.Set(x, y, c)
@@ -84,7 +117,7 @@ func drawYGridLine(vis *image.RGBA, y int) {
 
 // Utility function to draw a polar grid circle at the specified radius from
 // the center point.
-func drawPolarGridCircle(vis *image.RGBA, r float64) {
+func drawPolarGridCircle(vis *image.RGBA, r float64, g color.RGBA) {
 	x0 := vis.Bounds().Max.X / 2
 	y0 := vis.Bounds().Max.Y / 2
 	res := 2 * math.Pi * r
@@ -94,13 +127,13 @@ func drawPolarGridCircle(vis *image.RGBA, r float64) {
 		x := x0 + int(r*math.Cos(ϕ))
 		y := y0 + int(r*math.Sin(ϕ))
 		c := getRGBA(vis, x, y)
-		c.R, c.G, c.B = grid, grid, grid
+		c.R, c.G, c.B = g.R, g.G, g.B
 	}
 }
 
 // Utility function to draw tick marks at the specified radius from the center
 // point of a polar visualization.
-func drawPolarGridRadialTicks(vis *image.RGBA, r float64) {
+func drawPolarGridRadialTicks(vis *image.RGBA, r float64, g color.RGBA) {
 	w := vis.Bounds().Max.X
 	h := vis.Bounds().Max.Y
 	x0 := w / 2
@@ -111,27 +144,150 @@ func drawPolarGridRadialTicks(vis *image.RGBA, r float64) {
 	for _, y := range []int{y0 - int(r), y0 + int(r) - 1} {
 		for x := x0 - tickScale; x <= x0 + tickScale; x++ {
 			c := getRGBA(vis, x, y)
-			c.R, c.G, c.B = grid, grid, grid
+			c.R, c.G, c.B = g.R, g.G, g.B
 		}
 	}
 
 	for _, x := range []int{x0 - int(r), x0 + int(r) - 1} {
 		for y := y0 - tickScale; y <= y0 + tickScale; y++ {
 			c := getRGBA(vis, x, y)
-			c.R, c.G, c.B = grid, grid, grid
+			c.R, c.G, c.B = g.R, g.G, g.B
+		}
+	}
+}
+
+// Utility function to draw a stacked area chart, with one value per x-position
+// in each layer, and the first layer at the bottom of the stack. Values are
+// multiplied by the specified scale factor to get their heights in pixels.
+func drawStackedArea(
+	vis *image.RGBA,
+	layers [][]float64,
+	colors []color.RGBA,
+	scale float64) {
+
+	h := vis.Bounds().Max.Y
+	for x := 0; x < vis.Bounds().Max.X; x++ {
+		n := 0.0
+		for layer, values := range layers {
+			yMin := int(math.Ceil(n * scale))
+			n += values[x]
+			yMax := int(math.Ceil(n * scale))
+			for y := yMin; y < yMax; y++ {
+				*getRGBA(vis, x, h-y-1) = colors[layer]
+			}
+		}
+	}
+}
+
+// Utility function to find the highest point of a stacked area chart.
+func stackedMax(layers [][]float64) float64 {
+	maxValue := 0.0
+	if len(layers) == 0 {
+		return maxValue
+	}
+	for x := range layers[0] {
+		n := 0.0
+		for _, values := range layers {
+			n += values[x]
+		}
+		maxValue = math.Max(maxValue, n)
+	}
+	return maxValue
+}
+
+// Utility function to get the legend for the success, failure, and active
+// channels of the density-style visualizations, as they appear for a single
+// unstacked data point in the specified theme.
+func densityLegend(t *Theme) []legendEntry {
+	inks := t.densityInks()
+	return []legendEntry{
+		{t.inked(inks[0]), "success"},
+		{t.inked(inks[1]), "failure"},
+		{t.inked(inks[2]), "active"}}
+}
+
+// Utility function to composite the floating-point canvases for each event
+// status of the density-style visualizations onto an image, tinting each pixel
+// with the ink for each status in proportion to its intensity. Canvases are
+// expected to have the two-pixel bleed used for the bloom effect.
+func plotDensity(
+	vis *image.RGBA,
+	t *Theme,
+	cΔ float64,
+	s []float64,
+	f []float64,
+	a []float64) {
+
+	inks := t.densityInks()
+	plotLayers(vis, cΔ, [][]float64{s, f, a}, inks[:])
+}
+
+// Utility function to composite any number of floating-point canvases onto an
+// image, as is done by plotDensity, with the specified ink for each canvas.
+// The inks are mixed as fractions of a full step of color, which is then
+// applied in increments of cΔ.
+func plotLayers(
+	vis *image.RGBA,
+	cΔ float64,
+	layers [][]float64,
+	inks [][3]float64) {
+
+	steps := make([][3]float64, len(inks))
+	for l := range inks {
+		for j := range steps[l] {
+			steps[l][j] = inks[l][j] / saturated
+		}
+	}
+
+	w, h := vis.Bounds().Max.X, vis.Bounds().Max.Y
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := (y+2)*w + x + 2
+			var ink [3]float64
+			plotted := false
+			for l, layer := range layers {
+				if layer[i] > 0 {
+					for j := range ink {
+						ink[j] += layer[i] * steps[l][j]
+					}
+					plotted = true
+				}
+			}
+			if plotted {
+				tint(getRGBA(vis, x, y), ink, cΔ)
+			}
 		}
 	}
 }
 
-// Utility function get getting a shade of red to represent a class of failures
-// in a stack representing multiple failure types.
-func getErrorStackColor(layer int, layers int) color.RGBA {
-	v := float64(layer) * 255 / float64(layers)
-	return color.RGBA{
-		uint8(127 + v/2),
-		uint8(11 + v*2/3),
-		uint8(11 + v*2/3),
-		opaque}
+// Utility function to select a moving-window width which is appropriate for
+// the specified line-smoothing resonance, up to the specified maximum width.
+func smoothingWindow(width int, resonance float64) int {
+	window := 0
+	n := 1.0
+	for window < width && n > 0.02 {
+		n = n * resonance
+		window++
+	}
+	return window
+}
+
+// Utility function to add a data point at the specified position in a frame of
+// values, smoothed with a bi-directional variation on an exponential moving
+// average (which is implemented as a windowed FIR here for efficiency
+// purposes).
+func addSmoothed(frame []float64, x int, resonance float64, window int) {
+	frame[x] = frame[x] + 1
+	leftWindow := int(math.Min(float64(window), float64(x)))
+	for i, n := 1, 1.0; i < leftWindow; i++ {
+		n = n * resonance
+		frame[x-i] = frame[x-i] + n
+	}
+	rightWindow := int(math.Min(float64(window), float64(len(frame)-x-1)))
+	for i, n := 1, 1.0; i < rightWindow; i++ {
+		n = n * resonance
+		frame[x+i] = frame[x+i] + n
+	}
 }
 
 // Utility function to return a pointer to a pixel in an RGBA image, which can
@@ -159,13 +315,65 @@ func getRGBA(i *image.RGBA, x int, y int) *color.RGBA {
 }
 
 // Utility function for setting up a visualization canvas.
-func initializeVisualization(width int, height int, bg int) *image.RGBA {
+func initializeVisualization(width int, height int, bg color.RGBA) *image.RGBA {
 	vis := image.NewRGBA(image.Rect(0, 0, width, height))
-	background := color.RGBA{uint8(bg), uint8(bg), uint8(bg), opaque}
+	background := bg
 	draw.Draw(vis, vis.Bounds(), &image.Uniform{background}, image.ZP, draw.Src)
 	return vis
 }
 
+// Utility function to get an opaque gray color of the specified level.
+func grayRGBA(level int) color.RGBA {
+	return color.RGBA{uint8(level), uint8(level), uint8(level), opaque}
+}
+
+// Utility function to get the position of a run time along a log2 run-time
+// axis, in doublings from the run time at the axis's origin.
+func runTimeLog2(t float64, tMin float64) float64 {
+	return math.Log2(t / tMin)
+}
+
+// Utility function to add each value in one slice to the corresponding value in
+// another slice of the same length.
+func addFloat64s(dst []float64, src []float64) {
+	for i := range dst {
+		dst[i] += src[i]
+	}
+}
+
+// Utility function to get the sum of the values in a slice.
+func sumFloat64s(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
+
+// Utility function to add each value in one slice to the corresponding value in
+// another slice of the same length.
+func addInts(dst []int, src []int) {
+	for i := range dst {
+		dst[i] += src[i]
+	}
+}
+
+// Get the smaller of two integers (without a lot of casting)
+func intMin(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Get the larger of two integers (without a lot of casting)
+func intMax(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // Get the largest of three integers (without a lot of casting)
 func intMaxOfThree(a int, b int, c int) int {
 	if a > b {
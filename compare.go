@@ -0,0 +1,349 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"image"
+	"io"
+	"math"
+)
+
+// Comparator is implemented by generators of visualizations which contrast two
+// sets of events, such as this week against last week, or a canary region
+// against production. Events are recorded into the baseline and candidate
+// generators separately, and the rendered visualization shows where the density
+// of the candidate's events grew (in the theme's failure color) or shrank (in
+// the theme's success color) relative to the baseline. Densities are compared
+// as fractions of each set's total, so sets of different sizes can be compared.
+type Comparator interface {
+	Baseline() Visualizer
+	Candidate() Visualizer
+	Render() image.Image
+	RenderSVG(io.Writer) error
+}
+
+type scatterComparison struct {
+	base     *scatter // Generator for the baseline events
+	cand     *scatter // Generator for the candidate events
+	failures bool     // Whether to compare only failed events
+}
+
+// NewScatterComparison returns a comparator for scatter visualizations. The
+// baseline's time range is stretched over the same width as the candidate's,
+// so ranges of equal length will line up point-for-point. If failuresOnly is
+// set, only the density of failed events is compared.
+func NewScatterComparison(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	baseMinTime int,
+	baseMaxTime int,
+	yLog2 float64,
+	minRunTime float64,
+	colorSteps float64,
+	xGrid int,
+	failuresOnly bool,
+	labels bool,
+	jitter *Jitter) Comparator {
+
+	return &scatterComparison{
+		NewScatter(
+			width, height, theme, baseMinTime, baseMaxTime, yLog2,
+			minRunTime, colorSteps, xGrid, false, labels, jitter).(*scatter),
+		NewScatter(
+			width, height, theme, minTime, maxTime, yLog2,
+			minRunTime, colorSteps, xGrid, false, labels, jitter).(*scatter),
+		failuresOnly}
+}
+
+// Baseline returns the generator to record the baseline events into.
+func (v *scatterComparison) Baseline() Visualizer {
+	return v.base
+}
+
+// Candidate returns the generator to record the candidate events into.
+func (v *scatterComparison) Candidate() Visualizer {
+	return v.cand
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *scatterComparison) Render() image.Image {
+
+	vis := initializeVisualization(v.cand.w, v.cand.h, v.cand.theme.Background)
+	v.cand.drawGrid(vis)
+
+	plotDiverging(vis, v.cand.theme, v.cand.cΔ, v.difference())
+
+	if v.cand.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.cand.theme)
+		drawLegend(vis, legend, v.cand.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document. The differences stay raster, embedded as
+// an image between the vector grid and labels.
+func (v *scatterComparison) RenderSVG(out io.Writer) error {
+
+	// Plot the differences onto a bare canvas, which will then be embedded as a
+	// raster image atop the vector grid.
+	t := v.cand.theme
+	vis := initializeVisualization(v.cand.w, v.cand.h, t.Background)
+	plotDiverging(vis, t, v.cand.cΔ, v.difference())
+
+	svg := newSVGWriter(out, v.cand.w, v.cand.h, t)
+	v.cand.drawSVGGrid(svg)
+	svg.pixels(vis)
+
+	if v.cand.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Get the labels for the candidate's time and run-time axes, and the color
+// legend.
+func (v *scatterComparison) axisLabels() ([]label, []legendEntry) {
+	labels, _ := v.cand.axisLabels()
+	return labels, divergingLegend(v.cand.theme)
+}
+
+// Get the difference between the candidate's and the baseline's normalized
+// densities at each point of the floating-point canvas, scaled back up to the
+// mean of their totals so the color steps have the same meaning they do for a
+// plain scatter visualization.
+func (v *scatterComparison) difference() []float64 {
+	base, cand := v.density(v.base), v.density(v.cand)
+	nBase, nCand := sumFloat64s(base), sumFloat64s(cand)
+	return normalizedDifference(base, nBase, cand, nCand, (nBase+nCand)/2)
+}
+
+// Get the density of the events being compared on a scatter visualization's
+// floating-point canvas.
+func (v *scatterComparison) density(s *scatter) []float64 {
+	if v.failures {
+		return s.f
+	}
+	d := make([]float64, len(s.s))
+	addFloat64s(d, s.s)
+	addFloat64s(d, s.f)
+	addFloat64s(d, s.a)
+	return d
+}
+
+type histogramComparison struct {
+	base     *histogram // Generator for the baseline events
+	cand     *histogram // Generator for the candidate events
+	failures bool       // Whether to compare only failed events
+}
+
+// NewHistogramComparison returns a comparator for histogram visualizations,
+// drawn as masts rising above the midline where the candidate has a greater
+// share of its events and falling below it where it has a lesser share. If
+// failuresOnly is set, only the shares of failed events are compared.
+func NewHistogramComparison(
+	width int,
+	height int,
+	theme *Theme,
+	yLog2 float64,
+	minRunTime float64,
+	failuresOnly bool,
+	labels bool,
+	jitter *Jitter) Comparator {
+
+	return &histogramComparison{
+		NewHistogram(
+			width, height, theme, yLog2, minRunTime, labels,
+			jitter).(*histogram),
+		NewHistogram(
+			width, height, theme, yLog2, minRunTime, labels,
+			jitter).(*histogram),
+		failuresOnly}
+}
+
+// Baseline returns the generator to record the baseline events into.
+func (v *histogramComparison) Baseline() Visualizer {
+	return v.base
+}
+
+// Candidate returns the generator to record the candidate events into.
+func (v *histogramComparison) Candidate() Visualizer {
+	return v.cand
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *histogramComparison) Render() image.Image {
+
+	t := v.cand.theme
+	vis := initializeVisualization(v.cand.w, v.cand.h, t.Background)
+	v.cand.drawGrid(vis)
+	mid := v.cand.h / 2
+	drawYGridLine(vis, mid, t.Grid)
+
+	// Draw the masts, up from the midline for growth and down for shrinkage.
+	diff, scale := v.difference()
+	for x := 0; x < v.cand.w; x++ {
+		d := int(math.Ceil(math.Abs(diff[x]) * scale))
+		for y := 0; y < d; y++ {
+			if diff[x] > 0 {
+				vis.Set(x, mid-y-1, t.Failure)
+			} else {
+				vis.Set(x, mid+y+1, t.Success)
+			}
+		}
+	}
+
+	if v.cand.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, t)
+		drawLegend(vis, legend, t)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *histogramComparison) RenderSVG(out io.Writer) error {
+
+	t := v.cand.theme
+	svg := newSVGWriter(out, v.cand.w, v.cand.h, t)
+
+	// Draw vertical grid lines on each doubling of the run time in seconds.
+	for x := v.cand.yLog2; x < float64(v.cand.w); x += v.cand.yLog2 {
+		svg.xGridLine(int(x))
+	}
+	mid := v.cand.h / 2
+	svg.yGridLine(mid)
+
+	// Draw the masts, up from the midline for growth and down for shrinkage.
+	diff, scale := v.difference()
+	for x := 0; x < v.cand.w; x++ {
+		d := math.Ceil(math.Abs(diff[x]) * scale)
+		if diff[x] > 0 {
+			svg.rect(float64(x), float64(mid)-d, 1, d, t.Failure)
+		} else {
+			svg.rect(float64(x), float64(mid+1), 1, d, t.Success)
+		}
+	}
+
+	if v.cand.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Get the labels for the run-time axis, and the color legend.
+func (v *histogramComparison) axisLabels() ([]label, []legendEntry) {
+	legend := divergingLegend(v.cand.theme)
+	labels := runTimeLabelsX(v.cand.w, v.cand.h, v.cand.yLog2, v.cand.tMin)
+	return labelsLeftOf(labels, legendLeft(v.cand.w, legend)), legend
+}
+
+// Get the difference between the candidate's and the baseline's shares of
+// events at each x-position, along with the scale factor which will normalize
+// the largest difference to the height of half of the visualization.
+func (v *histogramComparison) difference() ([]float64, float64) {
+	base, cand := v.counts(v.base), v.counts(v.cand)
+	diff := normalizedDifference(
+		base, sumFloat64s(base), cand, sumFloat64s(cand), 1)
+	maxDiff := 0.0
+	for _, d := range diff {
+		maxDiff = math.Max(maxDiff, math.Abs(d))
+	}
+	if maxDiff == 0 {
+		return diff, 0
+	}
+	return diff, float64(v.cand.h/2-1) / maxDiff
+}
+
+// Get the counts of the events being compared at each x-position of a
+// histogram visualization.
+func (v *histogramComparison) counts(h *histogram) []float64 {
+	counts := make([]float64, h.w)
+	for x := range counts {
+		counts[x] = float64(h.fail[x])
+		if !v.failures {
+			counts[x] += float64(h.pass[x])
+		}
+	}
+	return counts
+}
+
+// Get the color legend for a comparison visualization.
+func divergingLegend(t *Theme) []legendEntry {
+	return []legendEntry{
+		{t.tinted(t.Failure, 1), "more"},
+		{t.tinted(t.Success, 1), "fewer"}}
+}
+
+// Utility function to get the difference between two sets of values, each
+// normalized by the specified total for its set and then scaled by the
+// specified factor. A set with no data is treated as having a density of zero
+// throughout.
+func normalizedDifference(
+	base []float64,
+	nBase float64,
+	cand []float64,
+	nCand float64,
+	scale float64) []float64 {
+
+	diff := make([]float64, len(cand))
+	for i := range diff {
+		if nCand > 0 {
+			diff[i] += cand[i] / nCand
+		}
+		if nBase > 0 {
+			diff[i] -= base[i] / nBase
+		}
+		diff[i] *= scale
+	}
+	return diff
+}
+
+// Utility function to composite a floating-point canvas of signed differences
+// onto an image, tinting each pixel with the ink for growth or shrinkage in
+// proportion to the magnitude of the difference. As with plotDensity, the
+// canvas is expected to have the two-pixel bleed used for the bloom effect.
+func plotDiverging(vis *image.RGBA, t *Theme, cΔ float64, diff []float64) {
+	w, h := vis.Bounds().Max.X, vis.Bounds().Max.Y
+	more, fewer := t.ink(t.Failure), t.ink(t.Success)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := diff[(y+2)*w+x+2]
+			if d > 0 {
+				tint(getRGBA(vis, x, y), more, d*cΔ/saturated)
+			} else if d < 0 {
+				tint(getRGBA(vis, x, y), fewer, -d*cΔ/saturated)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+type concurrency struct {
+	w      int          // Width of the visualization
+	h      int          // Height of the visualization
+	tA     float64      // Lower limit of time range to be visualized
+	tτ     float64      // Length of time range to be visualized
+	s      []int        // Running-count deltas for successful events by x-pos.
+	f      []int        // Running-count deltas for failed events by x-pos.
+	a      []int        // Running-count deltas for active events by x-pos.
+	xGrid  int          // Number of vertical grid divisions
+	theme  *Theme       // Colors to render the visualization with
+	labels bool         // Whether to draw axis labels and a legend
+	notes  []Annotation // Annotations to draw over the time axis
+}
+
+// NewConcurrency returns a concurrency-visualization generator, which shows
+// the number of events running at each point in time, broken down by the
+// final status of each event.
+func NewConcurrency(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	xGrid int,
+	labels bool) Visualizer {
+
+	return &concurrency{
+		width,
+		height,
+		float64(minTime),
+		float64(maxTime - minTime),
+		make([]int, width+1),
+		make([]int, width+1),
+		make([]int, width+1),
+		xGrid,
+		themeOrDefault(theme),
+		labels,
+		nil}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *concurrency) Record(e *EventData) {
+
+	// The event occupies every x-position from the one corresponding to its
+	// start time to the one corresponding to its end time. Active events are
+	// taken to still be running at the end of the visualized time range, and
+	// events with negative run times (from clock skew) are taken to have
+	// ended as soon as they started. Positions are floored rather than
+	// truncated, so events which ended just before the time range are not
+	// counted at its first x-position.
+	xA := int(math.Floor(float64(v.w) * (float64(e.Start) - v.tA) / v.tτ))
+	xΩ := int(math.Floor(
+		float64(v.w) * (float64(e.Start) + e.RunTime() - v.tA) / v.tτ))
+	if e.Status < 0 {
+		xΩ = v.w - 1
+	}
+	if xA >= v.w || xΩ < 0 {
+		return
+	}
+	xA = int(math.Max(0, float64(xA)))
+	xΩ = int(math.Min(float64(v.w-1), math.Max(float64(xA), float64(xΩ))))
+
+	// Rather than incrementing every x-position the event covers, which could
+	// be expensive for long-running events, we only mark where it enters and
+	// leaves the running count. The counts are then swept up at render time.
+	var frame []int
+	if e.Status == 0 {
+		frame = v.s
+	} else if e.Status > 0 {
+		frame = v.f
+	} else {
+		frame = v.a
+	}
+	frame[xA]++
+	frame[xΩ+1]--
+}
+
+// Clone returns an empty concurrency-visualization generator with the same
+// configuration as this one.
+func (v *concurrency) Clone() Visualizer {
+	c := *v
+	c.s = make([]int, len(v.s))
+	c.f = make([]int, len(v.f))
+	c.a = make([]int, len(v.a))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *concurrency) Merge(o Visualizer) {
+	other := o.(*concurrency)
+	addInts(v.s, other.s)
+	addInts(v.f, other.f)
+	addInts(v.a, other.a)
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *concurrency) Annotate(notes []Annotation) {
+	v.notes = notes
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *concurrency) Render() image.Image {
+
+	// Initialize our image canvas and grid.
+	layers := v.sweep()
+	scale := v.scale(layers)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
+		}
+	}
+	ticks := countTicks(v.h, scale)
+	for _, t := range ticks {
+		drawYGridLine(vis, t.pos, v.theme.Grid)
+	}
+
+	// Draw the running counts, with completed events at the bottom of the
+	// stack and active events at the top.
+	drawStackedArea(vis, layers, v.layerColors(), scale)
+
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels(ticks)
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *concurrency) RenderSVG(out io.Writer) error {
+
+	layers := v.sweep()
+	scale := v.scale(layers)
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+	ticks := countTicks(v.h, scale)
+	for _, t := range ticks {
+		svg.yGridLine(t.pos)
+	}
+
+	svg.stackedArea(layers, v.layerColors(), scale)
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels(ticks)
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the number of events of each status in flight at each
+// x-position.
+func (v *concurrency) Data() *SeriesData {
+	layers := v.sweep()
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     timeCoordinates(v.w, v.tA, v.tτ),
+		Series: map[string][]float64{
+			"success": layers[0],
+			"failure": layers[1],
+			"active":  layers[2]}}
+}
+
+// Sweep up the running-count deltas into the number of events of each status
+// running at each x-position, as layers of a stacked area chart.
+func (v *concurrency) sweep() [][]float64 {
+	var layers [][]float64
+	for _, deltas := range [][]int{v.s, v.f, v.a} {
+		n := 0
+		layer := make([]float64, v.w)
+		for x := 0; x < v.w; x++ {
+			n += deltas[x]
+			layer[x] = float64(n)
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// Get the scale factor which will normalize the height of the stacks, leaving
+// a little headroom above the highest point.
+func (v *concurrency) scale(layers [][]float64) float64 {
+	return float64(v.h) / math.Max(1, stackedMax(layers)*1.05)
+}
+
+// Get the colors for each layer of the stack.
+func (v *concurrency) layerColors() []color.RGBA {
+	return []color.RGBA{v.theme.Success, v.theme.Failure, v.theme.Active}
+}
+
+// Get the labels for the time and count axes, and the color legend.
+func (v *concurrency) axisLabels(ticks []tick) ([]label, []legendEntry) {
+	labels := timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ)
+	labels = append(labels, countLabels(ticks)...)
+	legend := []legendEntry{
+		{v.theme.Success, "success"},
+		{v.theme.Failure, "failure"},
+		{v.theme.Active, "active"}}
+	return labels, legend
+}
@@ -19,39 +19,43 @@ package perspective
 
 import (
 	"image"
+	"image/color"
+	"io"
 	"math"
 )
 
+// Amount of ink applied by each stroke of the lines, leaving room for the two
+// lines to build up in intensity where they cross.
+const countInk = 0.5
+
 type countLines struct {
-	w         int       // Width of the visualization
-	h         int       // Height of the visualization
-	tA        float64   // Lower limit of time range to be visualized
-	tτ        float64   // Length of time range to be visualized
-	s         []float64 // Counts of successful events by x-axis position
-	f         []float64 // Counts of failed events by x-axis position
-	resonance float64   // Inverse of geometric decay for moving-window
-	window    int       // Moving-window width
-	xGrid     int       // Number of vertical grid divisions
-	bg        int       // Background grey level
+	w         int          // Width of the visualization
+	h         int          // Height of the visualization
+	tA        float64      // Lower limit of time range to be visualized
+	tτ        float64      // Length of time range to be visualized
+	s         []float64    // Counts of successful events by x-axis position
+	f         []float64    // Counts of failed events by x-axis position
+	resonance float64      // Inverse of geometric decay for moving-window
+	window    int          // Moving-window width
+	xGrid     int          // Number of vertical grid divisions
+	theme     *Theme       // Colors to render the visualization with
+	labels    bool         // Whether to draw axis labels and a legend
+	notes     []Annotation // Annotations to draw over the time axis
 }
 
 // NewCountLines returns an line-graph event-count-visualization generator.
 func NewCountLines(
 	width int,
 	height int,
-	bg int,
+	theme *Theme,
 	minTime int,
 	maxTime int,
 	resonance float64,
-	xGrid int) Visualizer {
+	xGrid int,
+	labels bool) Visualizer {
 
 	// Select a window which is appropriate for the selected resonance
-	window := 0;
-	n := 1.0;
-	for  window < width && n > 0.02 {
-		n = n * resonance
-		window++
-	}
+	window := smoothingWindow(width, resonance)
 
 	return &countLines{
 		width,
@@ -63,15 +67,14 @@ func NewCountLines(
 		resonance,
 		window, //width / 42,
 		xGrid,
-		bg}
+		themeOrDefault(theme),
+		labels,
+		nil}
 }
 
 // Record accepts an EventData pointer and plots it onto the visualization.
 func (v *countLines) Record(e *EventData) {
 
-	resonance := v.resonance
-	window := v.window
-
 	// Position on the x-axis corresponds to the event's start time. Event run
 	// times are not taken into account in this visualization. A margin is left
 	// on each edge for smoothing purposes.
@@ -89,20 +92,29 @@ func (v *countLines) Record(e *EventData) {
 		frame = v.f
 	}
 
-	// Line is smothed with a bi-directional variation on an exponential moving
-	// average (which is implemented as a windowed FIR here for efficiency
-	// purposes).
-	frame[x] = frame[x] + 1
-	leftWindow := int(math.Min(float64(window), float64(x)))
-	for i, n := 1, 1.0; i < leftWindow; i++ {
-		n = n * resonance
-		frame[x-i] = frame[x-i] + n
-	}
-	rightWindow := int(math.Min(float64(window), float64(v.w-x-1)))
-	for i, n := 1, 1.0; i < rightWindow; i++ {
-		n = n * resonance
-		frame[x+i] = frame[x+i] + n
-	}
+	addSmoothed(frame, x, v.resonance, v.window)
+}
+
+// Clone returns an empty count-lines-visualization generator with the same
+// configuration as this one.
+func (v *countLines) Clone() Visualizer {
+	c := *v
+	c.s = make([]float64, len(v.s))
+	c.f = make([]float64, len(v.f))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *countLines) Merge(o Visualizer) {
+	other := o.(*countLines)
+	addFloat64s(v.s, other.s)
+	addFloat64s(v.f, other.f)
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *countLines) Annotate(notes []Annotation) {
+	v.notes = notes
 }
 
 // Render returns the visualization constructed from all previously-recorded
@@ -113,18 +125,14 @@ func (v *countLines) Render() image.Image {
 	stroke := v.h / 32
 
 	// Initialize our image canvas and grid.
-	vis := initializeVisualization(v.w, v.h, v.bg)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
 	v.drawGrid(vis)
 
-	// Find the highest point of the chart to normalize the height of the lines.
-	maxCount := float64(0)
-	for x := 0; x < v.w; x++ {
-		maxCount = math.Max(maxCount, v.s[x])
-		maxCount = math.Max(maxCount, v.f[x])
-	}
-	scale := float64(v.h) / (maxCount)
+	// Normalize the height of the lines to the highest point of the chart.
+	scale := v.scale()
 
 	// Draw the lines.
+	inks := v.theme.countInks()
 	var yMin, yMax int
 	for x := 1; x < v.w-1; x++ {
 
@@ -137,10 +145,7 @@ func (v *countLines) Render() image.Image {
 		yMin = intMinOfThree(sC - stroke, sP, sN)
 		yMax = sC
 		for y := yMin; y < yMax; y++ {
-			cS := getRGBA(vis, x, v.h-y)
-			cS.R += 24
-			cS.G += 24
-			cS.B += 128
+			tint(getRGBA(vis, x, v.h-y), inks[0], 1)
 		}
 
 		fP := int(math.Ceil(v.f[x-1] * scale))
@@ -149,35 +154,163 @@ func (v *countLines) Render() image.Image {
 		yMin = intMinOfThree(fC - stroke, fP, fN)
 		yMax = fC
 		for y := yMin; y < yMax; y++ {
-			cF := getRGBA(vis, x, v.h-y)
-			cF.R += 128
-			cF.G += 24
-			cF.B += 24
+			tint(getRGBA(vis, x, v.h-y), inks[1], 1)
 		}
 	}
 
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the time axis and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
 	return vis
 }
 
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *countLines) RenderSVG(out io.Writer) error {
+
+	// Stroke width (for visibility and calligraphic effect)
+	stroke := float64(v.h / 32)
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Render hatching to indicate dropoff at the end of the plot due to the
+	// smoothing window.
+	hatch := v.theme.inked(v.theme.hatchInk())
+	svg.printf(
+		"<defs><pattern id=\"hatch\" width=\"8\" height=\"8\" "+
+			"patternUnits=\"userSpaceOnUse\">"+
+			"<path d=\"M0,8 L8,0 M0,0 L8,8\" stroke=\"%s\" "+
+			"stroke-width=\"1.5\"/></pattern></defs>\n",
+		svgColor(hatch))
+	for _, x := range []int{0, v.w - v.window} {
+		svg.printf(
+			"<rect x=\"%d\" y=\"0\" width=\"%d\" height=\"%d\" "+
+				"fill=\"url(#hatch)\"/>\n",
+			x, v.window, v.h)
+	}
+
+	// Draw vertical grid lines, if vertical divisions were specified.
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+
+	// Draw the lines, offset by half of the stroke width so they sit along the
+	// same baseline as the raster rendering.
+	scale := v.scale()
+	inks := v.theme.countInks()
+	for _, line := range []struct {
+		frame []float64
+		c     color.RGBA
+	}{
+		{v.s, v.theme.inked(inks[0])},
+		{v.f, v.theme.inked(inks[1])},
+	} {
+		points := make([]float64, 0, 2*v.w)
+		for x := 1; x < v.w-1; x++ {
+			y := math.Ceil(line.frame[x] * scale)
+			points = append(points, float64(x), float64(v.h)-y+stroke/2)
+		}
+		svg.polyline(points, line.c, stroke)
+	}
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// RenderText writes the visualization constructed from all previously-recorded
+// data points out as colored sparklines for display in a terminal, with one
+// character for each pixel of the visualization's width. Successes are drawn
+// above failures, on the same scale.
+func (v *countLines) RenderText(out io.Writer) error {
+
+	text := newTextWriter(out, v.w)
+
+	rows := intMax(1, v.h/(2*cellHeight))
+	scale := v.scale() * float64(rows*cellHeight) / float64(v.h)
+	for _, line := range []struct {
+		frame []float64
+		c     color.RGBA
+	}{
+		{v.s, v.theme.Success},
+		{v.f, v.theme.Failure},
+	} {
+		c := line.c
+		text.sparkline(
+			line.frame, scale, rows, func(int) color.RGBA { return c })
+	}
+
+	if v.labels {
+		text.axis(textTimeLabels(v.w, v.tA, v.tτ))
+		text.legend([]legendEntry{
+			{v.theme.Success, "success"},
+			{v.theme.Failure, "failure"}})
+	}
+
+	return text.close()
+}
+
+// Data returns the smoothed counts of successful and failed events at each
+// x-position, as drawn by the lines.
+func (v *countLines) Data() *SeriesData {
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     timeCoordinates(v.w, v.tA, v.tτ),
+		Series: map[string][]float64{
+			"success": v.s,
+			"failure": v.f}}
+}
+
+// Get the labels for the time axis, and the color legend.
+func (v *countLines) axisLabels() ([]label, []legendEntry) {
+	inks := v.theme.countInks()
+	legend := []legendEntry{
+		{v.theme.inked(inks[0]), "success"},
+		{v.theme.inked(inks[1]), "failure"}}
+	return timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ), legend
+}
+
+// Find the highest point of the chart to get the scale factor which will
+// normalize the height of the lines.
+func (v *countLines) scale() float64 {
+	maxCount := float64(0)
+	for x := 0; x < v.w; x++ {
+		maxCount = math.Max(maxCount, v.s[x])
+		maxCount = math.Max(maxCount, v.f[x])
+	}
+	return float64(v.h) / (maxCount)
+}
+
 func (v *countLines) drawGrid(vis *image.RGBA) {
 
 	// Render hatching to indicate dropoff at the end of the plot due to the
 	// smoothing window.
+	hatch := v.theme.hatchInk()
 	for y := 0; y < v.h; y++ {
 		for x := 0; x < v.window; x++ {
 			if (x+y)%8 < 3 || (x-y)%8 == 0 {
-				cS := getRGBA(vis, x, y)
-				cS.R += 18
-				cS.G += 18
-				cS.B += 18
+				tint(getRGBA(vis, x, y), hatch, 1)
 			}
 		}
 		for x := v.w - v.window; x < v.w; x++ {
 			if (x+y)%8 < 3 || (x-y)%8 == 0 {
-				cS := getRGBA(vis, x, y)
-				cS.R += 18
-				cS.G += 18
-				cS.B += 18
+				tint(getRGBA(vis, x, y), hatch, 1)
 			}
 		}
 	}
@@ -185,7 +318,7 @@ func (v *countLines) drawGrid(vis *image.RGBA) {
 	// Draw vertical grid lines, if vertical divisions were specified.
 	if v.xGrid > 0 {
 		for i := 1; i < v.xGrid; i++ {
-			drawXGridLine(vis, i*v.w/v.xGrid)
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
 		}
 	}
 }
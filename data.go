@@ -0,0 +1,103 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"math"
+)
+
+// Units of the coordinates along the axes of exported series data.
+const (
+	TimeAxis     = "time"     // Seconds since the beginning of the Unix epoch
+	RunTimeAxis  = "run-time" // Elapsed run time in seconds
+	ProgressAxis = "progress" // Progress percentage
+	TypeAxis     = "type"     // Event type, for charts grouped by type
+	RegionAxis   = "region"   // Region, for charts grouped by region
+)
+
+// SeriesData is the aggregated data behind a visualization, binned by pixel
+// position just as it is drawn. X holds the coordinate at the start of each
+// bin along the x-axis, and each of the named series holds one value per bin.
+// Categorical charts hold one entry in X for each event type or region drawn,
+// in the order in which they are drawn.
+// Density visualizations also bin along the y-axis, with Y holding the
+// coordinate of each row from the top of the visualization down, and each of
+// the named grids holding one row of values per entry in Y. Bins in which no
+// events were recorded hold zero.
+type SeriesData struct {
+	XAxis  string                 `json:"xAxis"`
+	X      []float64              `json:"x"`
+	YAxis  string                 `json:"yAxis,omitempty"`
+	Y      []float64              `json:"y,omitempty"`
+	Series map[string][]float64   `json:"series,omitempty"`
+	Grids  map[string][][]float64 `json:"grids,omitempty"`
+}
+
+// Optional interface for visualization generators which can export the binned
+// data behind their visualizations, for charting or analysis by other tools.
+// The polar scatter visualization is the only one with no such export, since
+// its pixels are not binned along a time or run-time axis.
+type DataVisualizer interface {
+	Visualizer
+	Data() *SeriesData
+}
+
+// Utility function to get the time at the start of each x-position of a time
+// axis of the specified width.
+func timeCoordinates(w int, tA float64, tτ float64) []float64 {
+	coords := make([]float64, w)
+	for x := range coords {
+		coords[x] = tA + tτ*float64(x)/float64(w)
+	}
+	return coords
+}
+
+// Utility function to get the run time at the start of each position of a
+// log2 run-time axis of the specified length, with the specified run time at
+// its origin.
+func runTimeCoordinates(n int, log2 float64, tMin float64) []float64 {
+	coords := make([]float64, n)
+	for i := range coords {
+		coords[i] = tMin * math.Exp2(float64(i)/log2)
+	}
+	return coords
+}
+
+// Utility function to copy the values for each pixel of the visualization out
+// of a floating-point canvas, as rows from the top of the visualization down.
+// As with plotDensity, the canvas is expected to have the two-pixel bleed used
+// for the bloom effect.
+func densityGrid(frame []float64, w int, h int) [][]float64 {
+	grid := make([][]float64, h)
+	for y := range grid {
+		grid[y] = make([]float64, w)
+		for x := range grid[y] {
+			grid[y][x] = frame[(y+2)*w+x+2]
+		}
+	}
+	return grid
+}
+
+// Utility function to convert a slice of counts to floating-point values.
+func float64s(counts []int) []float64 {
+	values := make([]float64, len(counts))
+	for i, n := range counts {
+		values[i] = float64(n)
+	}
+	return values
+}
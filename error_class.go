@@ -0,0 +1,122 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+)
+
+// ErrorClassNamer is implemented by visualization generators which break failed
+// events down by error class, and can name the classes in their legends. The
+// name at each index is for the class with the status code one greater, so the
+// first name is for failures given without a reason. Classes without a name
+// are labelled by number.
+type ErrorClassNamer interface {
+	NameErrorClasses([]string)
+}
+
+// Utility function to get the legend text for an error class, numbered from
+// zero.
+func errorClassName(names []string, class int) string {
+	if class < len(names) && names[class] != "" {
+		return names[class]
+	}
+	if class == 0 {
+		return "no reason"
+	}
+	return fmt.Sprintf("class %d", class+1)
+}
+
+// Utility function to get the floating-point canvas of the specified size for
+// an error class, numbered from zero. Canvases are only allocated for classes
+// which have been seen, with the slots for any skipped classes left nil.
+func errorClassChannel(channels *[][]float64, class int, size int) []float64 {
+	for len(*channels) <= class {
+		*channels = append(*channels, nil)
+	}
+	if (*channels)[class] == nil {
+		(*channels)[class] = make([]float64, size)
+	}
+	return (*channels)[class]
+}
+
+// Utility function to fold one set of error-class canvases into another.
+func mergeErrorClassChannels(dst *[][]float64, src [][]float64, size int) {
+	for class, channel := range src {
+		if channel != nil {
+			addFloat64s(errorClassChannel(dst, class, size), channel)
+		}
+	}
+}
+
+// Utility function to sum a set of error-class canvases into a single canvas
+// of all failures.
+func sumErrorClassChannels(channels [][]float64, size int) []float64 {
+	sum := make([]float64, size)
+	for _, channel := range channels {
+		if channel != nil {
+			addFloat64s(sum, channel)
+		}
+	}
+	return sum
+}
+
+// Utility function to composite the success and active canvases of a
+// density-style visualization onto an image along with a canvas for each error
+// class, each tinted with its own error hue from the theme.
+func plotErrorClassDensity(
+	vis *image.RGBA,
+	t *Theme,
+	cΔ float64,
+	s []float64,
+	classes [][]float64,
+	a []float64) {
+
+	density := t.densityInks()
+	layers := [][]float64{s, a}
+	inks := [][3]float64{density[0], density[2]}
+	for class, channel := range classes {
+		if channel != nil {
+			layers = append(layers, channel)
+			inks = append(inks, t.ink(t.errorClassColor(class)))
+		}
+	}
+	plotLayers(vis, cΔ, layers, inks)
+}
+
+// Utility function to get the legend for a density-style visualization with
+// failures broken down by error class, listing only the classes seen.
+func errorClassDensityLegend(
+	t *Theme,
+	classes [][]float64,
+	names []string) []legendEntry {
+
+	density := t.densityInks()
+	legend := []legendEntry{{t.inked(density[0]), "success"}}
+	for class, channel := range classes {
+		if channel != nil {
+			legend = append(
+				legend,
+				legendEntry{
+					t.tinted(t.errorClassColor(class), 1),
+					errorClassName(names, class)})
+		}
+	}
+	return append(legend, legendEntry{t.inked(density[2]), "active"})
+}
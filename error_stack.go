@@ -0,0 +1,219 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+type errorStack struct {
+	w         int          // Width of the visualization
+	h         int          // Height of the visualization
+	tA        float64      // Lower limit of time range to be visualized
+	tτ        float64      // Length of time range to be visualized
+	classes   [][]float64  // Counts of failed events by class and x-position
+	resonance float64      // Inverse of geometric decay for moving-window
+	window    int          // Moving-window width
+	xGrid     int          // Number of vertical grid divisions
+	theme     *Theme       // Colors to render the visualization with
+	labels    bool         // Whether to draw axis labels and a legend
+	notes     []Annotation // Annotations to draw over the time axis
+	names     []string     // Names of error classes for the legend
+}
+
+// NewErrorStack returns a stacked-area failure-visualization generator, which
+// breaks failed events down over time by the error class assigned to their
+// status codes in conversion from CSV. Counts are smoothed over time in the
+// same manner as in the count-lines visualization.
+func NewErrorStack(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	resonance float64,
+	xGrid int,
+	labels bool) Visualizer {
+
+	return &errorStack{
+		width,
+		height,
+		float64(minTime),
+		float64(maxTime - minTime),
+		nil,
+		resonance,
+		smoothingWindow(width, resonance),
+		xGrid,
+		themeOrDefault(theme),
+		labels,
+		nil,
+		nil}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *errorStack) Record(e *EventData) {
+
+	// Only failed events are of interest in this visualization.
+	if e.Status <= 0 {
+		return
+	}
+
+	// Position on the x-axis corresponds to the event's start time.
+	x := int(float64(v.w) * (float64(e.Start) - v.tA) / v.tτ)
+	if x < 0 || x >= v.w {
+		return
+	}
+
+	// Error classes are numbered from 1, and we don't know how many of them
+	// there will be until we have seen them all, so layers are added to the
+	// stack as higher-numbered classes are encountered.
+	class := int(e.Status) - 1
+	for len(v.classes) <= class {
+		v.classes = append(v.classes, make([]float64, v.w))
+	}
+	addSmoothed(v.classes[class], x, v.resonance, v.window)
+}
+
+// Clone returns an empty error-stack-visualization generator with the same
+// configuration as this one.
+func (v *errorStack) Clone() Visualizer {
+	c := *v
+	c.classes = nil
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *errorStack) Merge(o Visualizer) {
+	other := o.(*errorStack)
+	for class, counts := range other.classes {
+		if class < len(v.classes) {
+			addFloat64s(v.classes[class], counts)
+		} else {
+			v.classes = append(v.classes, append([]float64(nil), counts...))
+		}
+	}
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *errorStack) Annotate(notes []Annotation) {
+	v.notes = notes
+}
+
+// NameErrorClasses sets the names of the error classes shown in the legend.
+func (v *errorStack) NameErrorClasses(names []string) {
+	v.names = names
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *errorStack) Render() image.Image {
+
+	// Initialize our image canvas and grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
+		}
+	}
+
+	// Draw the stacks, with the lowest-numbered error class at the bottom.
+	drawStackedArea(vis, v.classes, v.layerColors(), v.scale())
+
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the time axis and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *errorStack) RenderSVG(out io.Writer) error {
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+
+	svg.stackedArea(v.classes, v.layerColors(), v.scale())
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the smoothed counts of failed events of each error class at each
+// x-position, named as they are in the legend.
+func (v *errorStack) Data() *SeriesData {
+	_, legend := v.axisLabels()
+	series := make(map[string][]float64)
+	for class, counts := range v.classes {
+		series[legend[class].text] = counts
+	}
+	return &SeriesData{
+		XAxis:  TimeAxis,
+		X:      timeCoordinates(v.w, v.tA, v.tτ),
+		Series: series}
+}
+
+// Find the highest point of the stacks to get the scale factor which will
+// normalize their height.
+func (v *errorStack) scale() float64 {
+	return float64(v.h) / math.Max(1, stackedMax(v.classes))
+}
+
+// Get the colors for each layer of the stack.
+func (v *errorStack) layerColors() []color.RGBA {
+	colors := make([]color.RGBA, len(v.classes))
+	for layer := range v.classes {
+		colors[layer] = v.theme.errorStackColor(layer, len(v.classes))
+	}
+	return colors
+}
+
+// Get the labels for the time axis, and the color legend. Error class 1 is
+// always assigned to failures for which no reason was given, while the meaning
+// of the remaining classes depends on the error-reason filter configuration
+// used in conversion, which may also supply their names.
+func (v *errorStack) axisLabels() ([]label, []legendEntry) {
+	colors := v.layerColors()
+	legend := make([]legendEntry, len(colors))
+	for layer, c := range colors {
+		legend[layer] = legendEntry{c, errorClassName(v.names, layer)}
+	}
+	return timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ), legend
+}
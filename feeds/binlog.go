@@ -18,14 +18,19 @@
 package feeds
 
 import (
+	"bufio"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cparo/perspective"
 	"image/png"
 	"io"
 	"log"
+	"math"
 	"os"
 	"reflect"
+	"sync"
 	"syscall"
 	"unsafe"
 )
@@ -34,54 +39,231 @@ import (
 // listing of the data in the event records which match the specified filtering
 // criteria. These values are written as all int32 values for the sake of making
 // the output easier to consume with such things as a JavaScript Typed Array
-// parser (which lacks native support for such concepts as c-style structs).
+// parser (which lacks native support for such concepts as c-style structs),
+// with run times truncated to whole seconds. If wide values are requested,
+// they are instead written as all float64 values, with run times in seconds
+// including any fraction of a second, and with start times and IDs exactly
+// representable at any realistic value.
 func DumpEventData(
 	events *[]perspective.EventData,
-	tA int32,
-	tΩ int32,
+	tA int64,
+	tΩ int64,
 	typeFilter int,
 	regionFilter int,
 	statusFilter int,
+	wide bool,
 	out io.Writer) {
 
 	for i, _ := range *events {
 		e := (*perspective.EventData)(unsafe.Pointer(&(*events)[i]))
-		if eventFilter(e, tA, tΩ, typeFilter, regionFilter, statusFilter) {
+		if !eventFilter(e, tA, tΩ, typeFilter, regionFilter, statusFilter) {
+			continue
+		}
+		if !wide {
 			binary.Write(out, binary.LittleEndian, int32(e.ID))
 			binary.Write(out, binary.LittleEndian, int32(e.Start))
-			binary.Write(out, binary.LittleEndian, int32(e.Run))
+			binary.Write(
+				out,
+				binary.LittleEndian,
+				int32(e.Run/perspective.RunTimeUnitsPerSecond))
 			binary.Write(out, binary.LittleEndian, int32(e.Type))
 			binary.Write(out, binary.LittleEndian, int32(e.Status))
 			binary.Write(out, binary.LittleEndian, int32(e.Region))
 			binary.Write(out, binary.LittleEndian, int32(e.Progress))
+		} else {
+			binary.Write(out, binary.LittleEndian, float64(e.ID))
+			binary.Write(out, binary.LittleEndian, float64(e.Start))
+			binary.Write(out, binary.LittleEndian, e.RunTime())
+			binary.Write(out, binary.LittleEndian, float64(e.Type))
+			binary.Write(out, binary.LittleEndian, float64(e.Status))
+			binary.Write(out, binary.LittleEndian, float64(e.Region))
+			binary.Write(out, binary.LittleEndian, float64(e.Progress))
 		}
 	}
 }
 
 // GeneratePNGFromBinLog reads a binary-log formatted event-data dump and
 // renders a visualization as a PNG file using the specified visualization
-// generator and input-filtering parameters.
+// generator and input-filtering parameters. Events are recorded across the
+// specified number of worker goroutines if the visualization generator is
+// mergeable.
 func GeneratePNGFromBinLog(
 	events *[]perspective.EventData,
-	tA int32,
-	tΩ int32,
+	tA int64,
+	tΩ int64,
 	typeFilter int,
 	regionFilter int,
 	statusFilter int,
 	v perspective.Visualizer,
+	workers int,
 	out io.Writer) {
 
-	// Passing event data by reference instead of passing it by value cuts about
-	// 12-15% off of run time in repeated before/after tests with the scatter
-	// visualization through the HTTP API.
-	for i, _ := range *events {
-		e := (*perspective.EventData)(unsafe.Pointer(&(*events)[i]))
-		if eventFilter(e, tA, tΩ, typeFilter, regionFilter, statusFilter) {
-			v.Record(e)
-		}
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter, v, workers)
+	png.Encode(out, v.Render())
+}
+
+// GenerateSVGFromBinLog reads a binary-log formatted event-data dump and
+// renders a visualization as an SVG document using the specified visualization
+// generator and input-filtering parameters. Events are recorded as they are for
+// GeneratePNGFromBinLog. An error is returned if the visualization generator
+// has no vector renderer, or if the document could not be written.
+func GenerateSVGFromBinLog(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer,
+	workers int,
+	out io.Writer) error {
+
+	vv, ok := v.(perspective.VectorVisualizer)
+	if !ok {
+		return errors.New("visualization does not support SVG output")
+	}
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter, v, workers)
+	return vv.RenderSVG(out)
+}
+
+// GenerateTextFromBinLog reads a binary-log formatted event-data dump and
+// renders a visualization as text for display in a terminal, using the
+// specified visualization generator and input-filtering parameters. Events are
+// recorded as they are for GeneratePNGFromBinLog. An error is returned if the
+// visualization generator has no text renderer, or if the text could not be
+// written.
+func GenerateTextFromBinLog(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer,
+	workers int,
+	out io.Writer) error {
+
+	tv, ok := v.(perspective.TextVisualizer)
+	if !ok {
+		return errors.New("visualization does not support text output")
 	}
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter, v, workers)
+	return tv.RenderText(out)
+}
 
-	png.Encode(out, v.Render())
+// GenerateJSONFromBinLog reads a binary-log formatted event-data dump and
+// writes out the aggregated data behind a visualization as JSON, using the
+// specified visualization generator and input-filtering parameters. Events are
+// recorded as they are for GeneratePNGFromBinLog. An error is returned if the
+// visualization generator cannot export its data, or if the JSON could not be
+// written.
+func GenerateJSONFromBinLog(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer,
+	workers int,
+	out io.Writer) error {
+
+	dv, ok := v.(perspective.DataVisualizer)
+	if !ok {
+		return errors.New("visualization does not support data export")
+	}
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter, v, workers)
+	return json.NewEncoder(out).Encode(dv.Data())
+}
+
+// GenerateComparisonPNGFromBinLogs reads a pair of binary-log formatted
+// event-data dumps, each with its own time range, and renders a comparison of
+// the candidate events against the baseline events as a PNG file using the
+// specified comparator and input-filtering parameters. The baseline and
+// candidate may be the same dump, for comparing two time ranges of one feed.
+func GenerateComparisonPNGFromBinLogs(
+	baseEvents *[]perspective.EventData,
+	baseTA int64,
+	baseTΩ int64,
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	c perspective.Comparator,
+	workers int,
+	out io.Writer) {
+
+	RecordEvents(
+		baseEvents, baseTA, baseTΩ, typeFilter, regionFilter, statusFilter,
+		c.Baseline(), workers)
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter,
+		c.Candidate(), workers)
+	png.Encode(out, c.Render())
+}
+
+// GenerateComparisonSVGFromBinLogs is the vector equivalent of
+// GenerateComparisonPNGFromBinLogs, returning an error if the document could
+// not be written.
+func GenerateComparisonSVGFromBinLogs(
+	baseEvents *[]perspective.EventData,
+	baseTA int64,
+	baseTΩ int64,
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	c perspective.Comparator,
+	workers int,
+	out io.Writer) error {
+
+	RecordEvents(
+		baseEvents, baseTA, baseTΩ, typeFilter, regionFilter, statusFilter,
+		c.Baseline(), workers)
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter,
+		c.Candidate(), workers)
+	return c.RenderSVG(out)
+}
+
+// GetRunTimePercentiles reads a binary-log formatted event-data dump and writes
+// out the estimated run times, in seconds, at each of the specified
+// percentiles, one per line (like "p99 3600.5"). Events are recorded into the
+// specified estimator as they are for GeneratePNGFromBinLog, and percentiles
+// which cannot be estimated from them are written out as NaN. An error is
+// returned if the output could not be written.
+func GetRunTimePercentiles(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.PercentileEstimator,
+	percentiles []float64,
+	workers int,
+	out io.Writer) error {
+
+	RecordEvents(
+		events, tA, tΩ, typeFilter, regionFilter, statusFilter, v, workers)
+	for _, p := range percentiles {
+		t, ok := v.Percentile(p)
+		if !ok {
+			t = math.NaN()
+		}
+		if _, err := fmt.Fprintf(out, "p%g %.1f\n", p, t); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetSuccessRate reads a binary-log formatted event-data dump and writes out
@@ -90,8 +272,8 @@ func GeneratePNGFromBinLog(
 // a string percentage value of up to five places (like "99.997%").
 func GetSuccessRate(
 	events *[]perspective.EventData,
-	tA int32,
-	tΩ int32,
+	tA int64,
+	tΩ int64,
 	typeFilter int,
 	regionFilter int,
 	out io.Writer) {
@@ -116,12 +298,181 @@ func GetSuccessRate(
 	}
 }
 
+// GetSLOReport reads a binary-log formatted event-data dump and writes out a
+// JSON report on the error budget of the specified SLO as of the specified
+// time, with burn rates over each of the specified windows, given in seconds,
+// ending at that time. Only events starting at or after the specified start
+// time are counted. An error is returned if the JSON could not be written.
+func GetSLOReport(
+	events *[]perspective.EventData,
+	tA int64,
+	at int64,
+	slo perspective.SLO,
+	burnWindows []int,
+	out io.Writer) error {
+
+	t := perspective.NewSLOTracker(slo, int(at), burnWindows)
+	for i, _ := range *events {
+		e := (*perspective.EventData)(unsafe.Pointer(&(*events)[i]))
+		if e.Start >= tA {
+			t.Record(e)
+		}
+	}
+	return json.NewEncoder(out).Encode(t.Report())
+}
+
+// GetSuccessRateBreakdown reads a binary-log formatted event-data dump and
+// writes out the success rate of the completed events in each group of the
+// specified breakdown as a JSON array, in the order the groups are drawn.
+// Events are recorded as they are for GetSuccessRate. An error is returned if
+// the JSON could not be written.
+func GetSuccessRateBreakdown(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	v perspective.SuccessRateBreakdown,
+	workers int,
+	out io.Writer) error {
+
+	RecordEvents(events, tA, tΩ, typeFilter, regionFilter, 6, v, workers)
+	groups := v.Groups()
+	if groups == nil {
+		groups = []perspective.SuccessRateGroup{}
+	}
+	return json.NewEncoder(out).Encode(groups)
+}
+
+// Number of event records in each of the shards which RecordEvents splits
+// the event data into. Shards are a fixed size rather than one per worker, so
+// the result does not depend on the number of workers.
+const recordShardSize = 1 << 18
+
+// RecordEvents feeds each event record which matches the specified filtering
+// criteria to the specified visualization generator. If the generator is
+// mergeable, the event records are split into contiguous shards of a fixed
+// size which are recorded into clones of the generator, with up to the
+// specified number of workers recording shards concurrently, and the clones
+// are merged back into it in shard order. The result is the same for any
+// number of workers, down to the order in which floating-point values are
+// summed and the seeded jitter drawn for each shard, which is given its own
+// stream based on the shard's index.
+func RecordEvents(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer,
+	workers int) {
+
+	m, mergeable := v.(perspective.Mergeable)
+	if !mergeable {
+		recordEvents(
+			events, tA, tΩ, typeFilter, regionFilter, statusFilter, v)
+		return
+	}
+
+	// Shards are cloned for and merged back in order by this goroutine alone,
+	// with no more than one shard per worker recorded ahead of the next one
+	// to be merged, so that only that many clones are held at once.
+	n := (len(*events) + recordShardSize - 1) / recordShardSize
+	shards := make([]chan perspective.Visualizer, n)
+	for i := range shards {
+		shards[i] = make(chan perspective.Visualizer, 1)
+	}
+	next := 0
+	for i := range shards {
+		for next < n && (next == i || next < i+workers) {
+			start, end := next*recordShardSize, (next+1)*recordShardSize
+			if end > len(*events) {
+				end = len(*events)
+			}
+			shard := (*events)[start:end]
+			go func(s perspective.Visualizer, c chan perspective.Visualizer) {
+				recordEvents(
+					&shard, tA, tΩ, typeFilter, regionFilter, statusFilter, s)
+				c <- s
+			}(m.Clone(), shards[next])
+			next++
+		}
+		m.Merge(<-shards[i])
+	}
+}
+
+// Feed each event record which matches the specified filtering criteria to the
+// specified visualization generator.
+func recordEvents(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer) {
+
+	// Passing event data by reference instead of passing it by value cuts about
+	// 12-15% off of run time in repeated before/after tests with the scatter
+	// visualization through the HTTP API.
+	for i, _ := range *events {
+		e := (*perspective.EventData)(unsafe.Pointer(&(*events)[i]))
+		if eventFilter(e, tA, tΩ, typeFilter, regionFilter, statusFilter) {
+			v.Record(e)
+		}
+	}
+}
+
+// Memory mappings of the binary logs mapped by MapBinLogFile, keyed by the
+// event-data slices which were cast from them. The event records may begin
+// partway into a mapping (after the header, or after a partial record), so
+// the mapping itself is kept here for UnmapBinLogFile to release. Event data
+// not read directly from a mapping, as when it was upgraded from an earlier
+// version of the format or no records were selected, has no mapping.
+var (
+	mappings     = make(map[*[]perspective.EventData][]byte)
+	mappingsLock sync.Mutex
+)
+
+// MapBinLogFile maps a binary log into memory, returning its event records
+// (or only the specified number of records from the end of the log, if the
+// lookback is positive), or nil if the log could not be read. Logs with a
+// header are validated against the supported versions of the format, and
+// legacy logs without one are read as version 1 logs. Records of an earlier
+// version are upgraded to the current EventData layout as they are read, into
+// a copy of the log in memory. The records should be released with
+// UnmapBinLogFile once they are no longer needed.
 func MapBinLogFile(path string, lookback int64) *[]perspective.EventData {
+	events, _ := MapBinLogRange(
+		path, math.MinInt64, math.MaxInt64, Lookback{Events: lookback})
+	return events
+}
+
+// MapBinLogRange maps the part of a binary log which could hold events starting
+// within the specified time range into memory, as MapBinLogFile does for the
+// whole log, within the limits of the specified lookback. If the log has an
+// index, only the contiguous run of its blocks which could hold events in the
+// range is mapped, along with any records appended since the index was last
+// written. Otherwise the whole log (or its lookback) is mapped. A feed which
+// has been compacted into a segment is read the same way, with the blocks of
+// the segment which could hold events in the range decoded into memory. If the
+// lookback has a span, the start of the time range is moved forward to that
+// span before the latest start time in the log, which is found from the index
+// and any records beyond it, or by scanning the whole log if it has no index.
+// The start of the time range is returned along with the events, which must
+// still be filtered against it and the end of the range, as some may fall
+// outside it.
+func MapBinLogRange(
+	path string,
+	tA int64,
+	tΩ int64,
+	lookback Lookback) (*[]perspective.EventData, int64) {
 
 	iFile, err := os.Open(path)
 	if err != nil {
 		log.Println("Failed to open input file for reading.")
-		return nil
+		return nil, tA
 	}
 
 	defer iFile.Close()
@@ -129,25 +480,72 @@ func MapBinLogFile(path string, lookback int64) *[]perspective.EventData {
 	iStat, err := iFile.Stat()
 	if err != nil {
 		log.Println("Failed to stat input file.")
-		return nil
+		return nil, tA
 	}
 
 	fileSize := iStat.Size()
 
-	var start, length int64
-	// Multiply event lookback by event struct size to get the number of actual
-	// bytes we should seek back in the input feed.
-	seekback := lookback * int64(unsafe.Sizeof(perspective.EventData{}))
-	if seekback > 0 && seekback < fileSize {
-		start = fileSize - seekback
-		// Round down start position to fall on an even page boundary so the
-		// mmap will succeed:
-		start = start - start % int64(syscall.Getpagesize())
-		length = fileSize - start
-	} else {
-		start, length = 0, fileSize
+	if isSegment(iFile) {
+		return readSegmentRange(path, tA, tΩ, lookback)
 	}
 
+	// Find where the event records begin, after the header if there is one.
+	header, err := readBinLogHeader(iFile)
+	recordsStart := int64(binLogHeaderSize)
+	if err == nil && header == nil {
+		header, recordsStart = legacyBinLogHeader(), 0
+	}
+	if err == nil {
+		err = header.validate()
+	}
+	if err != nil {
+		log.Println("Failed to read binary-log header.")
+		log.Println(err)
+		return nil, tA
+	}
+	recordSize := int64(header.recordSize)
+
+	// Select the records to read, leaving out any partial record at the end of
+	// the log (as may be found while a writer is appending to it, or after one
+	// has crashed) so that the lookback counts back from the last whole record,
+	// and narrowing the selection to the relevant blocks of the index.
+	records := int64(0)
+	if fileSize > recordsStart {
+		records = (fileSize - recordsStart) / recordSize
+	}
+	lo, hi := int64(0), records
+	if lookback.Events > 0 && lookback.Events < records {
+		lo = records - lookback.Events
+	}
+
+	// The latest start time in the log, for the lookback's span, is taken from
+	// the index as far as it goes. Any records beyond it (or all of them, if
+	// there is no index) are mapped along with the selection to be scanned for
+	// it, and are left out of the events returned if they were not selected.
+	latest, scan := int64(math.MinInt64), int64(0)
+	if index := readBinLogIndex(BinLogIndexPath(path), records); index != nil {
+		latest, scan = index.latest(), index.records
+		lo, hi = index.narrow(lo, hi, lookback.start(tA, latest), tΩ)
+	}
+	mapLo := lo
+	if lookback.Span > 0 && scan < mapLo {
+		mapLo = scan
+	}
+
+	// A selection without any records has nothing to map.
+	if mapLo == hi {
+		events := &[]perspective.EventData{}
+		registerMapping(events, nil)
+		return events, lookback.start(tA, latest)
+	}
+
+	// Round down start position to fall on an even page boundary so the mmap
+	// will succeed, and skip ahead from there to the first selected record.
+	start := recordsStart + mapLo*recordSize
+	skip := start % int64(syscall.Getpagesize())
+	start -= skip
+	length := recordsStart + hi*recordSize - start
+
 	binLog, err := syscall.Mmap(
 		int(iFile.Fd()),
 		start,
@@ -156,7 +554,22 @@ func MapBinLogFile(path string, lookback int64) *[]perspective.EventData {
 		syscall.MAP_PRIVATE)
 	if err != nil {
 		log.Println("Failed to mmap input file.")
-		return nil
+		return nil, tA
+	}
+
+	selected := binLog[skip:]
+
+	// Records of an earlier version are copied out of the mapping as they are
+	// upgraded, so the mapping can be released straight away. The copy is
+	// still registered, so that UnmapBinLogFile accepts it as it does any
+	// other event data returned from here.
+	if header.version < BinLogVersion {
+		events := upgradeEventDataV1(selected)
+		syscall.Munmap(binLog)
+		tA = resolveLookbackSpan(
+			events, lo-mapLo, scan-mapLo, tA, latest, lookback)
+		registerMapping(events, nil)
+		return events, tA
 	}
 
 	// Using this mmap-and-cast method of parsing the input log instead of the
@@ -169,25 +582,107 @@ func MapBinLogFile(path string, lookback int64) *[]perspective.EventData {
 	// blank image canvas to a png file. Which should help to illustrate the
 	// absurd cost of avoiding an "unsafe" method for reading a file which would
 	// be considered perfectly valid in traditional systems development.
-	events := (*[]perspective.EventData)(unsafe.Pointer(&binLog))
+	events := (*[]perspective.EventData)(unsafe.Pointer(&selected))
 
 	// Correct the length and capacity of the events slice now that we have
 	// re-cast its type, so anything using that slice will know what to iterate
 	// over without running past the end.
-	header := (*reflect.SliceHeader)(unsafe.Pointer(events))
-	header.Len /= int(unsafe.Sizeof(perspective.EventData{}))
-	header.Cap /= int(unsafe.Sizeof(perspective.EventData{}))
+	sliceHeader := (*reflect.SliceHeader)(unsafe.Pointer(events))
+	sliceHeader.Len /= int(recordSize)
+	sliceHeader.Cap /= int(recordSize)
 
-	return events
+	tA = resolveLookbackSpan(
+		events, lo-mapLo, scan-mapLo, tA, latest, lookback)
+	registerMapping(events, binLog)
+	return events, tA
 }
 
+// Scan the mapped events from the specified offset onward for any start time
+// later than the latest one already known, resolve the lookback's span against
+// the latest start time found into the start of the time range, and drop the
+// events before the specified offset of the first selected record.
+func resolveLookbackSpan(
+	events *[]perspective.EventData,
+	selected int64,
+	scan int64,
+	tA int64,
+	latest int64,
+	lookback Lookback) int64 {
+
+	if lookback.Span > 0 && scan < int64(len(*events)) {
+		for _, e := range (*events)[scan:] {
+			if e.Start > latest {
+				latest = e.Start
+			}
+		}
+	}
+	*events = (*events)[selected:]
+	return lookback.start(tA, latest)
+}
+
+// Register the memory mapping, if any, from which event data was read, for
+// UnmapBinLogFile to release.
+func registerMapping(events *[]perspective.EventData, binLog []byte) {
+	mappingsLock.Lock()
+	mappings[events] = binLog
+	mappingsLock.Unlock()
+}
+
+// UnmapBinLogFile releases the memory mapping of a binary log mapped by
+// MapBinLogFile. The event records must not be used afterward.
 func UnmapBinLogFile(eventData *[]perspective.EventData) error {
 
-	mapping := (*[]byte)(unsafe.Pointer(eventData))
+	mappingsLock.Lock()
+	binLog, exists := mappings[eventData]
+	delete(mappings, eventData)
+	mappingsLock.Unlock()
+
+	if !exists {
+		return errors.New("event data is not a mapped binary log")
+	}
+	*eventData = nil
+	if binLog == nil {
+		return nil
+	}
+	return syscall.Munmap(binLog)
+}
+
+// UpgradeBinLogFile rewrites a binary log of any supported version of the
+// format as a log of the current version, with an index, at the specified
+// output path. The output is written to a temporary file alongside it and then
+// renamed into place, so a log can be upgraded in place. An error is returned
+// if the input log could not be read or the output log could not be written.
+func UpgradeBinLogFile(iPath string, oPath string) error {
 
-	header := (*reflect.SliceHeader)(unsafe.Pointer(eventData))
-	header.Len *= int(unsafe.Sizeof(perspective.EventData{}))
-	header.Cap *= int(unsafe.Sizeof(perspective.EventData{}))
+	events := MapBinLogFile(iPath, 0)
+	if events == nil {
+		return errors.New("failed to read binary log")
+	}
+	defer UnmapBinLogFile(events)
 
-	return syscall.Munmap(*mapping)
+	tmpPath := oPath + ".tmp"
+	oFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	binWriter := bufio.NewWriter(oFile)
+	err = writeBinLogHeader(binWriter)
+	if err == nil {
+		err = binary.Write(binWriter, binary.LittleEndian, *events)
+	}
+	if err == nil {
+		err = binWriter.Flush()
+	}
+	if closeErr := oFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, oPath); err != nil {
+		return err
+	}
+	return writeBinLogIndexFile(BinLogIndexPath(oPath), indexEvents(*events))
 }
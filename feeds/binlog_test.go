@@ -0,0 +1,116 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"github.com/cparo/perspective"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestV1BinLogIsUpgradedOnRead(t *testing.T) {
+	records := v1TestRecords(v1TestEvents())
+	for name, path := range map[string]string{
+		"legacy": writeTestFile(t, "legacy", records),
+		"v1":     writeTestFile(t, "v1", v1TestHeader(), records),
+	} {
+		events := MapBinLogFile(path, 0)
+		if events == nil {
+			t.Fatalf("%s log was not read", name)
+		}
+		if !reflect.DeepEqual(*events, upgradedTestEvents()) {
+			t.Errorf("%s log read as %v", name, *events)
+		}
+		UnmapBinLogFile(events)
+	}
+}
+
+func TestUpgradeBinLogFile(t *testing.T) {
+	records := v1TestRecords(v1TestEvents())
+	for name, iPath := range map[string]string{
+		"legacy": writeTestFile(t, "legacy", records),
+		"v1":     writeTestFile(t, "v1", v1TestHeader(), records),
+	} {
+		for _, inPlace := range []bool{false, true} {
+			oPath := iPath
+			if !inPlace {
+				oPath = filepath.Join(t.TempDir(), "upgraded")
+			}
+			if err := UpgradeBinLogFile(iPath, oPath); err != nil {
+				t.Fatalf("%s log failed to upgrade: %v", name, err)
+			}
+			checkUpgradedTestLog(t, name, oPath)
+
+			// Upgrading a log of the current version leaves it as it was.
+			if err := UpgradeBinLogFile(oPath, oPath); err != nil {
+				t.Fatalf("upgraded %s log failed to upgrade: %v", name, err)
+			}
+			checkUpgradedTestLog(t, name, oPath)
+		}
+	}
+}
+
+// Check that the log at the specified path, upgraded from a log of the named
+// kind, holds the events of v1TestEvents in the current version of the format,
+// with an index covering them.
+func checkUpgradedTestLog(t *testing.T, name string, path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := upgradedTestEvents()
+	if !bytes.HasPrefix(b, currentTestHeader(t)) {
+		t.Errorf("upgraded %s log has header %v", name, b[:binLogHeaderSize])
+	}
+	if size := binLogHeaderSize + 24*len(want); len(b) != size {
+		t.Errorf("upgraded %s log is %d bytes, want %d", name, len(b), size)
+	}
+
+	events := MapBinLogFile(path, 0)
+	if events == nil {
+		t.Fatalf("upgraded %s log was not read", name)
+	}
+	defer UnmapBinLogFile(events)
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("upgraded %s log read as %v", name, *events)
+	}
+
+	index := readBinLogIndex(BinLogIndexPath(path), int64(len(want)))
+	if index == nil || index.records != int64(len(want)) {
+		t.Errorf("upgraded %s log has index %+v", name, index)
+	}
+}
+
+// Get the events of v1TestEvents as they should be read in the current layout.
+func upgradedTestEvents() []perspective.EventData {
+	var events []perspective.EventData
+	for _, e := range v1TestEvents() {
+		events = append(events, perspective.EventData{
+			ID:       e.ID,
+			Type:     e.Type,
+			Status:   e.Status,
+			Region:   e.Region,
+			Progress: e.Progress,
+			Start:    int64(e.Start),
+			Run:      int64(e.Run) * perspective.RunTimeUnitsPerSecond})
+	}
+	return events
+}
@@ -24,8 +24,8 @@ import (
 
 func eventFilter(
 	event *perspective.EventData,
-	minTime int32,
-	maxTime int32,
+	minTime int64,
+	maxTime int64,
 	typeFilter int,
 	regionFilter int,
 	statusFilter int) bool {
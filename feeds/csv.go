@@ -25,6 +25,7 @@ import (
 	"github.com/cparo/perspective"
 	"io"
 	"log"
+	"math"
 	"os"
 	"regexp"
 	"strconv"
@@ -34,8 +35,8 @@ import (
 func ConvertCSVToBinary(
 	iPath string,
 	oPath string,
-	minTime int32,
-	maxTime int32,
+	minTime int64,
+	maxTime int64,
 	typeFilter int,
 	regionFilter int,
 	statusFilter int,
@@ -95,11 +96,17 @@ func ConvertCSVToBinary(
 
 	csvReader := csv.NewReader(bufio.NewReader(iFile))
 	binWriter := bufio.NewWriter(oFile)
+	panicOnError(
+		writeBinLogHeader(binWriter),
+		"Error writing header to binary log.")
+
+	index := &binLogIndex{}
 
 	var (
 		eventData     perspective.EventData
 		signedValue   int64
 		unsignedValue uint64
+		floatValue    float64
 	)
 
 	for {
@@ -112,7 +119,7 @@ func ConvertCSVToBinary(
 		// 0) event_id
 		// 1) event_type_id
 		// 2) event_start_time (in seconds since UNIX epoch)
-		// 3) event_run_time (in seconds)
+		// 3) event_run_time (in seconds, with an optional fraction)
 		// 4) exit_status (success if 0, >0 for failure, <0 for in-progress)
 		// 5) event_region (region identifier)
 		// 6) event_progress (percentage value)
@@ -125,9 +132,9 @@ func ConvertCSVToBinary(
 		panicOnError(err, "Error encountered parsing event type.")
 		eventData.Type = uint8(unsignedValue)
 
-		signedValue, err = strconv.ParseInt(fields[2], 10, 32)
+		signedValue, err = strconv.ParseInt(fields[2], 10, 64)
 		panicOnError(err, "Error encountered parsing event start time.")
-		eventData.Start = int32(signedValue)
+		eventData.Start = signedValue
 
 		if eventFilter(
 			&eventData,
@@ -141,9 +148,10 @@ func ConvertCSVToBinary(
 			panicOnError(err, "Error encountered parsing event ID.")
 			eventData.ID = int32(signedValue)
 
-			signedValue, err = strconv.ParseInt(fields[3], 10, 32)
+			floatValue, err = strconv.ParseFloat(fields[3], 64)
 			panicOnError(err, "Error encountered parsing event run time.")
-			eventData.Run = int32(signedValue)
+			eventData.Run = int64(
+				math.Floor(floatValue*perspective.RunTimeUnitsPerSecond + 0.5))
 
 			signedValue, err = strconv.ParseInt(fields[4], 10, 8)
 			panicOnError(err, "Error encountered parsing event status.")
@@ -165,10 +173,47 @@ func ConvertCSVToBinary(
 			panicOnError(
 				binary.Write(binWriter, binary.LittleEndian, eventData),
 				"Error writing event data to binary log.")
+			index.add(&eventData)
 		}
 	}
 
 	panicOnError(binWriter.Flush(), "Error flushing data to binary log.")
+	panicOnError(
+		writeBinLogIndexFile(BinLogIndexPath(oPath), index),
+		"Error writing binary-log index.")
+}
+
+// ReadErrorClassNames reads the names of the error classes assigned by an
+// error-reason filter config file, from the optional second field of each
+// line, for labelling visualizations which break failures down by error class.
+// The names are in order of error class, starting with the implied class for
+// failures given without a reason and ending with the implied class for
+// failures matching none of the filters. Lines without a name give an empty
+// string.
+func ReadErrorClassNames(errorReasonFilterConf string) ([]string, error) {
+	cFile, err := os.Open(errorReasonFilterConf)
+	if err != nil {
+		return nil, err
+	}
+	defer cFile.Close()
+	confReader := csv.NewReader(bufio.NewReader(cFile))
+	confReader.Comma = '|'
+	confReader.FieldsPerRecord = -1
+	names := []string{"no reason"}
+	for {
+		fields, err := confReader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := ""
+		if len(fields) > 1 {
+			name = strings.TrimSpace(fields[1])
+		}
+		names = append(names, name)
+	}
+	return append(names, "other"), nil
 }
 
 func atEOF(err error, message string) bool {
@@ -0,0 +1,174 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/cparo/perspective"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// Binary logs begin with a fixed-size header which identifies the format, so
+// that a log written with a different layout of event records is rejected
+// rather than silently misread. The header is laid out as follows, with the
+// event records following it back to back:
+//
+//	bytes 0-3    magic number, "PRSP"
+//	byte  4      byte order of the header fields below and of the event
+//	             records, 'L' for little-endian or 'B' for big-endian
+//	bytes 5-7    reserved, zero
+//	bytes 8-9    format version, as an unsigned integer
+//	bytes 10-11  size of each event record in bytes, as an unsigned integer
+//	bytes 12-15  reserved, zero
+//
+// Version 1 logs hold 16-byte records with 32-bit start times and run times in
+// whole seconds, and version 2 logs hold the 24-byte records of the current
+// EventData layout, with 64-bit start times and run times in microseconds.
+// Logs written before the header was introduced begin directly with their
+// event records. These are still read, as version 1 logs in the host's byte
+// order, as they always were. A legacy log whose first event ID happens to
+// spell out the magic number would be misread as having a header, but this is
+// vanishingly unlikely for real event IDs.
+const (
+	BinLogVersion    = 2  // Version of the format written by this package
+	binLogHeaderSize = 16 // Size of the header, in bytes
+)
+
+// Magic number identifying a binary log with a header.
+var binLogMagic = []byte("PRSP")
+
+// Layout of the event records of version 1 binary logs.
+type eventDataV1 struct {
+	ID       int32 // Event identifier
+	Start    int32 // In seconds since the beginning of the Unix epoch
+	Run      int32 // Event run time, in seconds
+	Type     uint8 // Event type indication
+	Status   int8  // 0 for success, >0 for failure, <0 for in-progress
+	Region   uint8 // Region identifier, 0 if undefined
+	Progress uint8 // Event progress percentage
+}
+
+// Size in bytes of the event records of each version of the binary-log format,
+// indexed by version.
+var binLogRecordSizes = []int{
+	0,
+	int(unsafe.Sizeof(eventDataV1{})),
+	int(unsafe.Sizeof(perspective.EventData{}))}
+
+// Fields of a binary-log header.
+type binLogHeader struct {
+	order      binary.ByteOrder // Byte order of the event records
+	version    int              // Format version
+	recordSize int              // Size of each event record, in bytes
+}
+
+// Write a binary-log header for logs of the current format, with records
+// written in little-endian byte order.
+func writeBinLogHeader(out io.Writer) error {
+	header := make([]byte, binLogHeaderSize)
+	copy(header, binLogMagic)
+	header[4] = 'L'
+	binary.LittleEndian.PutUint16(header[8:], BinLogVersion)
+	binary.LittleEndian.PutUint16(
+		header[10:], uint16(unsafe.Sizeof(perspective.EventData{})))
+	_, err := out.Write(header)
+	return err
+}
+
+// Read the header of a binary log, returning nil if the log has no header.
+// An error is returned if the header is malformed, or if it could not be read.
+func readBinLogHeader(in *os.File) (*binLogHeader, error) {
+	header := make([]byte, binLogHeaderSize)
+	n, err := in.ReadAt(header, 0)
+	if n < len(binLogMagic) || !bytes.Equal(header[:4], binLogMagic) {
+		return nil, nil
+	}
+	if n < binLogHeaderSize {
+		return nil, fmt.Errorf("truncated binary-log header: %v", err)
+	}
+	h := &binLogHeader{}
+	switch header[4] {
+	case 'L':
+		h.order = binary.LittleEndian
+	case 'B':
+		h.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unrecognized byte order: %q", header[4])
+	}
+	h.version = int(h.order.Uint16(header[8:]))
+	h.recordSize = int(h.order.Uint16(header[10:]))
+	return h, nil
+}
+
+// Get the header implied for a legacy binary log written without one.
+func legacyBinLogHeader() *binLogHeader {
+	return &binLogHeader{nativeByteOrder(), 1, binLogRecordSizes[1]}
+}
+
+// Check that the event records of a binary log with the specified header can
+// be read on this host, either mapped directly into memory as EventData
+// structs or upgraded from an earlier version of the format.
+func (h *binLogHeader) validate() error {
+	if h.order != nativeByteOrder() {
+		return fmt.Errorf("byte order %v does not match host", h.order)
+	}
+	if h.version < 1 || h.version > BinLogVersion {
+		return fmt.Errorf("unsupported binary-log version: %d", h.version)
+	}
+	if size := binLogRecordSizes[h.version]; h.recordSize != size {
+		return fmt.Errorf(
+			"record size %d does not match version %d record size %d",
+			h.recordSize,
+			h.version,
+			size)
+	}
+	return nil
+}
+
+// Upgrade the version 1 event records in the specified bytes into a newly
+// allocated slice of EventData structs.
+func upgradeEventDataV1(records []byte) *[]perspective.EventData {
+	size := binLogRecordSizes[1]
+	events := make([]perspective.EventData, len(records)/size)
+	for i := range events {
+		old := (*eventDataV1)(unsafe.Pointer(&records[i*size]))
+		events[i] = perspective.EventData{
+			ID:       old.ID,
+			Type:     old.Type,
+			Status:   old.Status,
+			Region:   old.Region,
+			Progress: old.Progress,
+			Start:    int64(old.Start),
+			Run:      int64(old.Run) * perspective.RunTimeUnitsPerSecond}
+	}
+	return &events
+}
+
+// Get the byte order of the host, in which memory-mapped event records are
+// read.
+func nativeByteOrder() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
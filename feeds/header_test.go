@@ -0,0 +1,151 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBinLogHeaderLayout(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeBinLogHeader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		'P', 'R', 'S', 'P', 'L', 0, 0, 0,
+		BinLogVersion, 0, 24, 0, 0, 0, 0, 0}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("header = %v, want %v", buf.Bytes(), want)
+	}
+}
+
+func TestBinLogHeaderRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		header     []byte
+		version    int
+		recordSize int
+	}{
+		{currentTestHeader(t), BinLogVersion, 24},
+		{v1TestHeader(), 1, 16},
+	} {
+		path := writeTestFile(t, "log", c.header)
+		h := readTestHeader(t, path)
+		if h == nil {
+			t.Fatalf("version %d header was not found", c.version)
+		}
+		if h.order != binary.LittleEndian {
+			t.Errorf("version %d byte order = %v", c.version, h.order)
+		}
+		if h.version != c.version || h.recordSize != c.recordSize {
+			t.Errorf(
+				"header = version %d with %d-byte records, "+
+					"want version %d with %d-byte records",
+				h.version, h.recordSize, c.version, c.recordSize)
+		}
+		if err := h.validate(); err != nil {
+			t.Errorf("version %d header failed validation: %v", c.version, err)
+		}
+	}
+}
+
+func TestLegacyBinLogHasNoHeader(t *testing.T) {
+	records := v1TestRecords(v1TestEvents())
+	path := writeTestFile(t, "log", records)
+	if h := readTestHeader(t, path); h != nil {
+		t.Errorf("legacy log read with header %+v", h)
+	}
+}
+
+func TestBinLogHeaderRejected(t *testing.T) {
+	badOrder := currentTestHeader(t)
+	badOrder[4] = 'X'
+	badVersion := currentTestHeader(t)
+	badVersion[8] = BinLogVersion + 1
+	badSize := v1TestHeader()
+	badSize[10] = 24
+	for name, header := range map[string][]byte{
+		"truncated": currentTestHeader(t)[:binLogHeaderSize-1],
+		"order":     badOrder,
+		"version":   badVersion,
+		"size":      badSize,
+	} {
+		path := writeTestFile(t, "log", header)
+		if events := MapBinLogFile(path, 0); events != nil {
+			t.Errorf("log with bad %s header was read", name)
+			UnmapBinLogFile(events)
+		}
+	}
+}
+
+// Get the header written for logs of the current version of the format.
+func currentTestHeader(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := writeBinLogHeader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Get a header for a version 1 log.
+func v1TestHeader() []byte {
+	return []byte{'P', 'R', 'S', 'P', 'L', 0, 0, 0, 1, 0, 16, 0, 0, 0, 0, 0}
+}
+
+// Get a set of version 1 event records covering each field's range of values.
+func v1TestEvents() []eventDataV1 {
+	return []eventDataV1{
+		{1, 1700000000, 0, 0, 0, 0, 100},
+		{2, 1700000060, 3600, 1, 1, 2, 50},
+		{-3, 1699999999, -5, 255, -1, 255, 0},
+		{1 << 30, 2147483647, 1 << 30, 7, 127, 1, 99}}
+}
+
+// Encode version 1 event records as they are laid out in a log.
+func v1TestRecords(events []eventDataV1) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, events)
+	return buf.Bytes()
+}
+
+// Write the specified chunks of data out to a new file with the specified name
+// in a temporary directory, returning its path.
+func writeTestFile(t *testing.T, name string, chunks ...[]byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, bytes.Join(chunks, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Read the header of the log at the specified path.
+func readTestHeader(t *testing.T, path string) *binLogHeader {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	h, err := readBinLogHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,291 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/cparo/perspective"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A binary log may be accompanied by a sparse index of the start times of its
+// events, in a sidecar file named for the log with an ".idx" suffix, so that a
+// query over a time range can skip the parts of the log which hold no events
+// in that range. The index divides the log into blocks of a fixed number of
+// records, and holds the earliest and latest start time in each block. Events
+// are not necessarily logged in order of start time (an appended log is
+// typically in order of completion instead), so a block's range of start times
+// may overlap those of its neighbours, but blocks are only skipped when their
+// range misses the query entirely, so the index is correct for any ordering.
+// The index is laid out as follows, with the start-time ranges of the blocks
+// following it back to back, as pairs of 64-bit integers:
+//
+//	bytes 0-3    magic number, "PRSI"
+//	byte  4      byte order of the fields below, 'L' for little-endian
+//	bytes 5-7    reserved, zero
+//	bytes 8-9    index format version, as an unsigned integer
+//	bytes 10-11  reserved, zero
+//	bytes 12-15  count of records in each block, as an unsigned integer
+//	bytes 16-23  count of records in the log covered by the index
+//
+// Records appended to the log after the index was last written are not covered
+// by it, and are always read.
+const (
+	binLogIndexVersion    = 1    // Version of the index format
+	binLogIndexHeaderSize = 24   // Size of the index header, in bytes
+	binLogIndexBlockSize  = 1024 // Count of records in each index block
+)
+
+// Magic number identifying a binary-log index.
+var binLogIndexMagic = []byte("PRSI")
+
+// Lookback limits how far back from the end of a binary log its records are
+// read, as a count of records, as a span of time before the latest start time
+// in the log, or both. Zero values place no limit. A span of time is resolved
+// into the start of the time range of a query, which is moved forward as far
+// as the span requires.
+type Lookback struct {
+	Events int64 // Count of records to read back from the end of the log
+	Span   int64 // Seconds to read back from the latest start time
+}
+
+// ParseLookback parses a lookback as a whole number of records, such as
+// "100000", or as a span of time with a unit suffix in the form accepted by
+// perspective.ParseDuration, such as "6h" or "7d".
+func ParseLookback(s string) (Lookback, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Lookback{Events: n}, nil
+	}
+	span, err := perspective.ParseDuration(s)
+	if err != nil {
+		return Lookback{}, err
+	}
+	return Lookback{Span: int64(span)}, nil
+}
+
+// Range of start times of the events in a block of records.
+type indexBlock struct {
+	min int64 // Earliest start time in the block
+	max int64 // Latest start time in the block
+}
+
+// Sparse index of the start times of the events in a binary log.
+type binLogIndex struct {
+	blocks  []indexBlock // Start-time range of each block of records
+	records int64        // Count of records covered by the index
+	dirty   int          // First block changed since the index was written
+}
+
+// BinLogIndexPath returns the path of the sidecar index of the binary log at
+// the specified path.
+func BinLogIndexPath(path string) string {
+	return path + ".idx"
+}
+
+// BuildBinLogIndex builds the sidecar index of the binary log at the specified
+// path, replacing any existing index. An error is returned if the log could
+// not be read or the index could not be written.
+func BuildBinLogIndex(path string) error {
+	events := MapBinLogFile(path, 0)
+	if events == nil {
+		return errors.New("failed to read binary log")
+	}
+	defer UnmapBinLogFile(events)
+	return writeBinLogIndexFile(BinLogIndexPath(path), indexEvents(*events))
+}
+
+// Build an index of the specified events.
+func indexEvents(events []perspective.EventData) *binLogIndex {
+	x := &binLogIndex{}
+	for i := range events {
+		x.add(&events[i])
+	}
+	return x
+}
+
+// Extend the index to cover an event appended to the log.
+func (x *binLogIndex) add(e *perspective.EventData) {
+	b := int(x.records / binLogIndexBlockSize)
+	if b == len(x.blocks) {
+		x.blocks = append(x.blocks, indexBlock{e.Start, e.Start})
+	} else if e.Start < x.blocks[b].min {
+		x.blocks[b].min = e.Start
+	} else if e.Start > x.blocks[b].max {
+		x.blocks[b].max = e.Start
+	}
+	x.records++
+}
+
+// Write out the header of the index and any blocks changed since it was last
+// written.
+func (x *binLogIndex) write(out io.WriterAt) error {
+	header := make([]byte, binLogIndexHeaderSize)
+	copy(header, binLogIndexMagic)
+	header[4] = 'L'
+	binary.LittleEndian.PutUint16(header[8:], binLogIndexVersion)
+	binary.LittleEndian.PutUint32(header[12:], binLogIndexBlockSize)
+	binary.LittleEndian.PutUint64(header[16:], uint64(x.records))
+
+	if x.dirty < len(x.blocks) {
+		entries := make([]byte, 16*(len(x.blocks)-x.dirty))
+		for i, block := range x.blocks[x.dirty:] {
+			binary.LittleEndian.PutUint64(entries[16*i:], uint64(block.min))
+			binary.LittleEndian.PutUint64(entries[16*i+8:], uint64(block.max))
+		}
+		offset := int64(binLogIndexHeaderSize + 16*x.dirty)
+		if _, err := out.WriteAt(entries, offset); err != nil {
+			return err
+		}
+	}
+
+	// The header is written after the blocks, so that it never claims to
+	// cover records whose blocks have not been written.
+	if _, err := out.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	// Only the last block, if it is still partial, can change again.
+	x.dirty = int(x.records / binLogIndexBlockSize)
+	return nil
+}
+
+// Write an index out to a new file at the specified path, replacing any file
+// already there.
+func writeBinLogIndexFile(path string, x *binLogIndex) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	x.dirty = 0
+	err = x.write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Read the index at the specified path, returning nil if there is no index
+// there, or if it is malformed or covers more records than the specified
+// count of records in its log (as when the log was replaced without the index
+// being rebuilt), so that the log is read without an index instead.
+func readBinLogIndex(path string, records int64) *binLogIndex {
+	b, err := os.ReadFile(path)
+	if err != nil || len(b) < binLogIndexHeaderSize {
+		return nil
+	}
+	if !bytes.Equal(b[:4], binLogIndexMagic) || b[4] != 'L' ||
+		binary.LittleEndian.Uint16(b[8:]) != binLogIndexVersion ||
+		binary.LittleEndian.Uint32(b[12:]) != binLogIndexBlockSize {
+
+		return nil
+	}
+	x := &binLogIndex{records: int64(binary.LittleEndian.Uint64(b[16:]))}
+	n := int((x.records + binLogIndexBlockSize - 1) / binLogIndexBlockSize)
+	if x.records > records || len(b) < binLogIndexHeaderSize+16*n {
+		return nil
+	}
+	x.blocks = make([]indexBlock, n)
+	for i := range x.blocks {
+		entry := b[binLogIndexHeaderSize+16*i:]
+		x.blocks[i].min = int64(binary.LittleEndian.Uint64(entry))
+		x.blocks[i].max = int64(binary.LittleEndian.Uint64(entry[8:]))
+	}
+	x.dirty = int(x.records / binLogIndexBlockSize)
+	return x
+}
+
+// Narrow the range of records [lo, hi) of a log to the contiguous run of
+// blocks which could hold events starting within the specified time range,
+// along with any records not covered by the index, which could hold anything.
+func (x *binLogIndex) narrow(
+	lo int64,
+	hi int64,
+	tA int64,
+	tΩ int64) (int64, int64) {
+
+	// Find the first and last blocks within the range of records which could
+	// hold events in the time range.
+	first, last := -1, -1
+	for i := int(lo / binLogIndexBlockSize); i < len(x.blocks); i++ {
+		if int64(i)*binLogIndexBlockSize >= hi {
+			break
+		}
+		if x.blocks[i].max >= tA && x.blocks[i].min <= tΩ {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+
+	// Records beyond the index are always read, so the range ends where it
+	// did if it reaches past the index, and starts no later than the index's
+	// end if no indexed blocks are relevant.
+	newLo, newHi := x.records, x.records
+	if first >= 0 {
+		newLo = int64(first) * binLogIndexBlockSize
+		newHi = int64(last+1) * binLogIndexBlockSize
+	}
+	if hi > x.records {
+		newHi = hi
+	}
+	if newLo < lo {
+		newLo = lo
+	}
+	if newHi > hi {
+		newHi = hi
+	}
+	if newLo > newHi {
+		newLo = newHi
+	}
+	return newLo, newHi
+}
+
+// Get the latest start time of the events covered by the index, or the lowest
+// possible time if it covers none.
+func (x *binLogIndex) latest() int64 {
+	latest := int64(math.MinInt64)
+	for _, block := range x.blocks {
+		if block.max > latest {
+			latest = block.max
+		}
+	}
+	return latest
+}
+
+// Resolve the span of a lookback into the start of a time range, moving the
+// specified start forward to the specified span before the latest start time
+// in a log, if the lookback has a span and the log has any events.
+func (l Lookback) start(tA int64, latest int64) int64 {
+	if l.Span > 0 && latest != math.MinInt64 && latest-l.Span > tA {
+		return latest - l.Span
+	}
+	return tA
+}
@@ -0,0 +1,182 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"github.com/cparo/perspective"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndexNarrowAtBlockEdges(t *testing.T) {
+
+	// Three full blocks of events starting one second apart, so that the
+	// first block covers start times 10000-11023, the second 11024-12047,
+	// and the third 12048-13071.
+	x := indexEvents(orderedTestEvents(3 * binLogIndexBlockSize))
+	n := x.records
+	for _, c := range []struct {
+		lo, hi, tA, tΩ int64 // Arguments to narrow
+		wantLo, wantHi int64 // Range of records it should return
+	}{
+		{0, n, math.MinInt64, math.MaxInt64, 0, n},
+		{0, n, 11023, 11023, 0, 1024},
+		{0, n, 11024, 11024, 1024, 2048},
+		{0, n, 11023, 11024, 0, 2048},
+		{0, n, 12047, 12048, 1024, 3072},
+		{0, n, 0, 9999, n, n},
+		{0, n, 13072, 20000, n, n},
+		{1023, n, 0, 20000, 1023, n},
+		{1024, n, 11023, 11023, n, n},
+		{1025, n, 11024, 11024, 1025, 2048},
+		{0, 1024, 11024, 11024, 1024, 1024},
+		{0, 1025, 11024, 11024, 1024, 1025},
+
+		// Records beyond the end of the index are always read.
+		{0, n + 5, 20000, 30000, n, n + 5},
+		{0, n + 5, 11023, 11023, 0, n + 5},
+	} {
+		lo, hi := x.narrow(c.lo, c.hi, c.tA, c.tΩ)
+		if lo != c.wantLo || hi != c.wantHi {
+			t.Errorf(
+				"narrow(%d, %d, %d, %d) = [%d, %d), want [%d, %d)",
+				c.lo, c.hi, c.tA, c.tΩ, lo, hi, c.wantLo, c.wantHi)
+		}
+	}
+}
+
+func TestIndexLookbackStart(t *testing.T) {
+	x := indexEvents(testEvents(3*binLogIndexBlockSize + 1))
+	latest := int64(math.MinInt64)
+	for _, e := range testEvents(3*binLogIndexBlockSize + 1) {
+		if e.Start > latest {
+			latest = e.Start
+		}
+	}
+	if x.latest() != latest {
+		t.Errorf("latest() = %d, want %d", x.latest(), latest)
+	}
+	if empty := (&binLogIndex{}).latest(); empty != math.MinInt64 {
+		t.Errorf("latest() of empty index = %d", empty)
+	}
+
+	for _, c := range []struct {
+		lookback Lookback
+		tA       int64
+		want     int64
+	}{
+		{Lookback{}, 0, 0},
+		{Lookback{Events: 10}, 0, 0},
+		{Lookback{Span: 3600}, 0, latest - 3600},
+		{Lookback{Span: 3600}, latest - 60, latest - 60},
+	} {
+		if start := c.lookback.start(c.tA, latest); start != c.want {
+			t.Errorf(
+				"%+v.start(%d, %d) = %d, want %d",
+				c.lookback, c.tA, latest, start, c.want)
+		}
+	}
+	if start := (Lookback{Span: 3600}).start(5, math.MinInt64); start != 5 {
+		t.Errorf("lookback start with no events = %d, want 5", start)
+	}
+}
+
+func TestIndexFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.idx")
+	x := indexEvents(testEvents(2*binLogIndexBlockSize + 3))
+	if err := writeBinLogIndexFile(path, x); err != nil {
+		t.Fatal(err)
+	}
+	read := readBinLogIndex(path, x.records)
+	if read == nil || read.records != x.records ||
+		!reflect.DeepEqual(read.blocks, x.blocks) {
+
+		t.Errorf("index read back as %+v, want %+v", read, x)
+	}
+
+	// An index covering more records than its log is stale, and is ignored.
+	if stale := readBinLogIndex(path, x.records-1); stale != nil {
+		t.Errorf("stale index read as %+v", stale)
+	}
+
+	// So is an index with a different block size.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[12]++
+	path = writeTestFile(t, "other.idx", b)
+	if other := readBinLogIndex(path, x.records); other != nil {
+		t.Errorf("index with other block size read as %+v", other)
+	}
+}
+
+func TestMapBinLogRangeWithIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	all := testEvents(5*binLogIndexBlockSize + 17)
+	appendTestEvents(t, path, all)
+
+	for _, c := range []struct {
+		tA, tΩ   int64
+		lookback Lookback
+		maxRead  int // Most records which should be read
+	}{
+		{1700000100, 1700000200, Lookback{}, binLogIndexBlockSize},
+		{1700000000, 1700002000, Lookback{Events: 2000}, 2000},
+		{math.MinInt64, math.MaxInt64, Lookback{Span: 300}, len(all)},
+		{1700000100, 1700000200, Lookback{Span: 300}, len(all)},
+	} {
+		events, tA := MapBinLogRange(path, c.tA, c.tΩ, c.lookback)
+		if events == nil {
+			t.Fatal("log was not read")
+		}
+		if len(*events) > c.maxRead {
+			t.Errorf("%+v: read %d records", c, len(*events))
+		}
+
+		// Every event which could be selected must be among those read.
+		selected := all
+		if c.lookback.Events > 0 {
+			selected = all[len(all)-int(c.lookback.Events):]
+		}
+		got := make(map[perspective.EventData]bool)
+		for _, e := range *events {
+			got[e] = true
+		}
+		for _, e := range selected {
+			if e.Start > tA && e.Start < c.tΩ && !got[e] {
+				t.Errorf("%+v: event %+v was not read", c, e)
+				break
+			}
+		}
+		UnmapBinLogFile(events)
+	}
+}
+
+// Get the specified count of events, starting one second apart from 10000.
+func orderedTestEvents(n int) []perspective.EventData {
+	events := make([]perspective.EventData, n)
+	for i := range events {
+		events[i].ID = int32(i)
+		events[i].Start = 10000 + int64(i)
+	}
+	return events
+}
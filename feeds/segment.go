@@ -0,0 +1,563 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cparo/perspective"
+	"hash/crc32"
+	"log"
+	"math/bits"
+	"os"
+	"syscall"
+)
+
+// Sealed feeds, which will not be appended to again, may be compacted into a
+// segment: a block-columnar encoding of their event records which is several
+// times smaller than a binary log. A segment begins with a header, laid out as
+// follows, and is followed by its blocks back to back:
+//
+//	bytes 0-3    magic number, "PRSC"
+//	byte  4      byte order of the fields below, 'L' for little-endian
+//	bytes 5-7    reserved, zero
+//	bytes 8-9    segment format version, as an unsigned integer
+//	bytes 10-11  reserved, zero
+//	bytes 12-15  count of records in each block, as an unsigned integer
+//
+// Every block but the last holds the full count of records, in their order in
+// the feed, so blocks line up with those of a binary-log index. Each block
+// begins with a header of its own, laid out as follows, followed by its
+// payload:
+//
+//	bytes 0-3    count of records in the block
+//	bytes 4-7    size of the payload, in bytes
+//	bytes 8-11   CRC-32 (IEEE) checksum of the payload
+//	bytes 12-15  reserved, zero
+//	bytes 16-23  earliest start time in the block
+//	bytes 24-31  latest start time in the block
+//
+// The payload holds a column for each field of the records in turn. IDs are
+// encoded as differences from the previous ID, and start times as differences
+// from the previous start time (or from the earliest, for the first), both as
+// zigzag varints. Run times are encoded as zigzag varints. The type, status,
+// region, and progress fields are each dictionary-coded, as a count of
+// distinct values less one, the distinct values, and then a code for each
+// record indexing into them, packed into as few bits as will hold the largest
+// code (none at all, if every record has the same value).
+const (
+	segmentVersion         = 1                    // Segment format version
+	segmentHeaderSize      = 16                   // Size of the file header
+	segmentBlockHeaderSize = 32                   // Size of each block header
+	segmentBlockSize       = binLogIndexBlockSize // Count of records in a block
+)
+
+// Magic number identifying a segment.
+var segmentMagic = []byte("PRSC")
+
+// Location, size, and start-time range of a block of a segment.
+type segmentBlock struct {
+	offset int    // Offset of the block's payload in the segment
+	size   int    // Size of the block's payload, in bytes
+	count  int    // Count of records in the block
+	crc    uint32 // Checksum of the block's payload
+	min    int64  // Earliest start time in the block
+	max    int64  // Latest start time in the block
+}
+
+// Segment is a compacted feed, mapped into memory for reading.
+type Segment struct {
+	data    []byte         // Memory mapping of the segment
+	blocks  []segmentBlock // Blocks of the segment, in order
+	records int64          // Count of records in the segment
+}
+
+// OpenSegment maps a segment into memory and reads the headers of its blocks.
+// The segment should be closed once it is no longer needed. An error is
+// returned if the segment could not be read or is malformed.
+func OpenSegment(path string) (*Segment, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < segmentHeaderSize {
+		return nil, errors.New("truncated segment header")
+	}
+
+	data, err := syscall.Mmap(
+		int(file.Fd()),
+		0,
+		int(stat.Size()),
+		syscall.PROT_READ,
+		syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	s := &Segment{data: data}
+	if err := s.readBlockHeaders(); err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close releases the memory mapping of the segment. Events read from it must
+// not be used afterward.
+func (s *Segment) Close() error {
+	data := s.data
+	s.data, s.blocks = nil, nil
+	return syscall.Munmap(data)
+}
+
+// Len returns the count of records in the segment.
+func (s *Segment) Len() int64 {
+	return s.records
+}
+
+// Events returns an iterator over the events of the segment, skipping blocks
+// which hold no events starting within the specified time range. Events in
+// the blocks which are read are yielded whether or not they fall in the range.
+func (s *Segment) Events(tA int64, tΩ int64) *SegmentIterator {
+	return &SegmentIterator{segment: s, tA: tA, tΩ: tΩ}
+}
+
+// Check the header of the segment and read the headers of its blocks.
+func (s *Segment) readBlockHeaders() error {
+	header := s.data[:segmentHeaderSize]
+	if !bytes.Equal(header[:4], segmentMagic) || header[4] != 'L' {
+		return errors.New("not a segment")
+	}
+	if v := binary.LittleEndian.Uint16(header[8:]); v != segmentVersion {
+		return fmt.Errorf("unsupported segment version: %d", v)
+	}
+	if n := binary.LittleEndian.Uint32(header[12:]); n != segmentBlockSize {
+		return fmt.Errorf("unsupported segment block size: %d", n)
+	}
+
+	for offset := segmentHeaderSize; offset < len(s.data); {
+		if len(s.data)-offset < segmentBlockHeaderSize {
+			return errors.New("truncated segment block header")
+		}
+		h := s.data[offset:]
+		b := segmentBlock{
+			offset + segmentBlockHeaderSize,
+			int(binary.LittleEndian.Uint32(h[4:])),
+			int(binary.LittleEndian.Uint32(h)),
+			binary.LittleEndian.Uint32(h[8:]),
+			int64(binary.LittleEndian.Uint64(h[16:])),
+			int64(binary.LittleEndian.Uint64(h[24:]))}
+		if b.size > len(s.data)-b.offset {
+			return errors.New("truncated segment block")
+		}
+		n := len(s.blocks)
+		if n > 0 && s.blocks[n-1].count != segmentBlockSize {
+			return errors.New("partial segment block before the last")
+		}
+		if b.count < 1 || b.count > segmentBlockSize {
+			return fmt.Errorf("malformed segment block size: %d", b.count)
+		}
+		s.blocks = append(s.blocks, b)
+		s.records += int64(b.count)
+		offset = b.offset + b.size
+	}
+	return nil
+}
+
+// Get an index of the start times of the segment's blocks, which line up with
+// those of a binary-log index.
+func (s *Segment) index() *binLogIndex {
+	x := &binLogIndex{records: s.records}
+	for _, b := range s.blocks {
+		x.blocks = append(x.blocks, indexBlock{b.min, b.max})
+	}
+	return x
+}
+
+// Decode the records of a block of the segment into the specified slice,
+// reusing its storage if it has room for them.
+func (s *Segment) decodeBlock(
+	i int,
+	events []perspective.EventData) ([]perspective.EventData, error) {
+
+	b := s.blocks[i]
+	payload := s.data[b.offset : b.offset+b.size]
+	if crc32.ChecksumIEEE(payload) != b.crc {
+		return nil, fmt.Errorf("checksum mismatch in segment block %d", i)
+	}
+	events, err := decodeSegmentColumns(payload, b.min, b.count, events)
+	if err != nil {
+		return nil, fmt.Errorf("malformed segment block %d: %v", i, err)
+	}
+	return events, nil
+}
+
+// SegmentIterator yields the events of a segment one at a time, decoding them
+// a block at a time.
+type SegmentIterator struct {
+	segment *Segment                // Segment being iterated over
+	tA      int64                   // Start of the time range of blocks read
+	tΩ      int64                   // End of the time range of blocks read
+	next    int                     // Index of the next block to read
+	events  []perspective.EventData // Events decoded from the current block
+	i       int                     // Index of the next event in the block
+	err     error                   // Error which ended the iteration, if any
+}
+
+// Next returns the next event of the segment, or nil once all of the events
+// have been read or an error has been encountered. The event is only valid
+// until the following call to Next.
+func (it *SegmentIterator) Next() *perspective.EventData {
+	for it.i == len(it.events) {
+		if it.err != nil || it.next == len(it.segment.blocks) {
+			return nil
+		}
+		b := it.segment.blocks[it.next]
+		if b.max >= it.tA && b.min <= it.tΩ {
+			it.events, it.err = it.segment.decodeBlock(it.next, it.events)
+			it.i = 0
+		}
+		it.next++
+	}
+	it.i++
+	return &it.events[it.i-1]
+}
+
+// Err returns the error, if any, which ended the iteration early.
+func (it *SegmentIterator) Err() error {
+	return it.err
+}
+
+// RecordSegmentEvents records the events of a segment which match the specified
+// filtering criteria into a visualization generator, as RecordEvents does for
+// the events of a binary log, but reading them a block at a time rather than
+// decoding the whole segment into memory. An error is returned if the segment
+// is found to be malformed partway through, in which case only the events
+// before the malformed block will have been recorded.
+func RecordSegmentEvents(
+	s *Segment,
+	tA int64,
+	tΩ int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	v perspective.Visualizer) error {
+
+	it := s.Events(tA, tΩ)
+	for e := it.Next(); e != nil; e = it.Next() {
+		if eventFilter(e, tA, tΩ, typeFilter, regionFilter, statusFilter) {
+			v.Record(e)
+		}
+	}
+	return it.Err()
+}
+
+// CompactBinLogFile rewrites a binary log of any supported version of the
+// format as a segment at the specified output path. The output is written to
+// a temporary file alongside it and then renamed into place, so a log can be
+// compacted in place, and any index left at the output path is removed, as it
+// would not apply to the segment. An error is returned if the input log could
+// not be read or the segment could not be written.
+func CompactBinLogFile(iPath string, oPath string) error {
+
+	events := MapBinLogFile(iPath, 0)
+	if events == nil {
+		return errors.New("failed to read binary log")
+	}
+	defer UnmapBinLogFile(events)
+
+	tmpPath := oPath + ".tmp"
+	oFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	header := make([]byte, segmentHeaderSize)
+	copy(header, segmentMagic)
+	header[4] = 'L'
+	binary.LittleEndian.PutUint16(header[8:], segmentVersion)
+	binary.LittleEndian.PutUint32(header[12:], segmentBlockSize)
+
+	segWriter := bufio.NewWriter(oFile)
+	_, err = segWriter.Write(header)
+	for i := 0; i < len(*events) && err == nil; i += segmentBlockSize {
+		j := i + segmentBlockSize
+		if j > len(*events) {
+			j = len(*events)
+		}
+		_, err = segWriter.Write(encodeSegmentBlock((*events)[i:j]))
+	}
+	if err == nil {
+		err = segWriter.Flush()
+	}
+	if closeErr := oFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, oPath); err != nil {
+		return err
+	}
+	if err := os.Remove(BinLogIndexPath(oPath)); !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Check whether a file begins with the magic number of a segment.
+func isSegment(in *os.File) bool {
+	magic := make([]byte, len(segmentMagic))
+	n, _ := in.ReadAt(magic, 0)
+	return n == len(magic) && bytes.Equal(magic, segmentMagic)
+}
+
+// Decode the events of a segment which could fall within the specified time
+// range and lookback into memory, as MapBinLogRange does for a binary log,
+// returning nil if the segment could not be read. The block headers of a
+// segment cover all of its events, so the latest start time against which the
+// lookback's span is resolved is found from them alone.
+func readSegmentRange(
+	path string,
+	tA int64,
+	tΩ int64,
+	lookback Lookback) (*[]perspective.EventData, int64) {
+
+	s, err := OpenSegment(path)
+	if err != nil {
+		log.Println("Failed to open segment.")
+		log.Println(err)
+		return nil, tA
+	}
+	defer s.Close()
+
+	lo, hi := int64(0), s.records
+	if lookback.Events > 0 && lookback.Events < s.records {
+		lo = s.records - lookback.Events
+	}
+	index := s.index()
+	tA = lookback.start(tA, index.latest())
+	lo, hi = index.narrow(lo, hi, tA, tΩ)
+
+	events := make([]perspective.EventData, 0, hi-lo)
+	var block []perspective.EventData
+	for i := lo / segmentBlockSize; i*segmentBlockSize < hi; i++ {
+		block, err = s.decodeBlock(int(i), block)
+		if err != nil {
+			log.Println("Failed to decode segment.")
+			log.Println(err)
+			return nil, tA
+		}
+		first, last := i*segmentBlockSize, i*segmentBlockSize+int64(len(block))
+		if lo > first {
+			block = block[lo-first:]
+		}
+		if hi < last {
+			block = block[:int64(len(block))-(last-hi)]
+		}
+		events = append(events, block...)
+	}
+	registerMapping(&events, nil)
+	return &events, tA
+}
+
+// Encode a block of events, with its header.
+func encodeSegmentBlock(events []perspective.EventData) []byte {
+	min, max := events[0].Start, events[0].Start
+	for i := range events {
+		if events[i].Start < min {
+			min = events[i].Start
+		}
+		if events[i].Start > max {
+			max = events[i].Start
+		}
+	}
+
+	var payload []byte
+	prevID, prevStart := int64(0), min
+	for i := range events {
+		payload = binary.AppendVarint(payload, int64(events[i].ID)-prevID)
+		prevID = int64(events[i].ID)
+	}
+	for i := range events {
+		payload = binary.AppendVarint(payload, events[i].Start-prevStart)
+		prevStart = events[i].Start
+	}
+	for i := range events {
+		payload = binary.AppendVarint(payload, events[i].Run)
+	}
+	values := make([]byte, len(events))
+	for _, field := range []func(*perspective.EventData) byte{
+		func(e *perspective.EventData) byte { return e.Type },
+		func(e *perspective.EventData) byte { return byte(e.Status) },
+		func(e *perspective.EventData) byte { return e.Region },
+		func(e *perspective.EventData) byte { return e.Progress }} {
+
+		for i := range events {
+			values[i] = field(&events[i])
+		}
+		payload = appendDictColumn(payload, values)
+	}
+
+	block := make(
+		[]byte, segmentBlockHeaderSize, segmentBlockHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(block, uint32(len(events)))
+	binary.LittleEndian.PutUint32(block[4:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(block[8:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint64(block[16:], uint64(min))
+	binary.LittleEndian.PutUint64(block[24:], uint64(max))
+	return append(block, payload...)
+}
+
+// Decode the columns of a block payload holding the specified count of events,
+// with the specified earliest start time, into the specified slice, reusing its
+// storage if it has room for them.
+func decodeSegmentColumns(
+	payload []byte,
+	min int64,
+	count int,
+	events []perspective.EventData) ([]perspective.EventData, error) {
+
+	if cap(events) < count {
+		events = make([]perspective.EventData, count)
+	}
+	events = events[:count]
+
+	prevID, prevStart := int64(0), min
+	for column := 0; column < 3; column++ {
+		for i := range events {
+			v, n := binary.Varint(payload)
+			if n <= 0 {
+				return nil, errors.New("truncated varint column")
+			}
+			payload = payload[n:]
+			switch column {
+			case 0:
+				prevID += v
+				events[i].ID = int32(prevID)
+			case 1:
+				prevStart += v
+				events[i].Start = prevStart
+			case 2:
+				events[i].Run = v
+			}
+		}
+	}
+
+	values := make([]byte, count)
+	var err error
+	for column := 0; column < 4; column++ {
+		payload, err = readDictColumn(payload, values)
+		if err != nil {
+			return nil, err
+		}
+		for i := range events {
+			switch column {
+			case 0:
+				events[i].Type = values[i]
+			case 1:
+				events[i].Status = int8(values[i])
+			case 2:
+				events[i].Region = values[i]
+			case 3:
+				events[i].Progress = values[i]
+			}
+		}
+	}
+	if len(payload) != 0 {
+		return nil, errors.New("trailing data after columns")
+	}
+	return events, nil
+}
+
+// Append a dictionary-coded column of byte values to a block payload.
+func appendDictColumn(payload []byte, values []byte) []byte {
+	var dict []byte
+	var codes [256]int
+	for i := range codes {
+		codes[i] = -1
+	}
+	for _, v := range values {
+		if codes[v] < 0 {
+			codes[v] = len(dict)
+			dict = append(dict, v)
+		}
+	}
+	payload = append(payload, byte(len(dict)-1))
+	payload = append(payload, dict...)
+
+	width := bits.Len(uint(len(dict) - 1))
+	var acc uint
+	accBits := 0
+	for _, v := range values {
+		acc |= uint(codes[v]) << accBits
+		for accBits += width; accBits >= 8; accBits -= 8 {
+			payload = append(payload, byte(acc))
+			acc >>= 8
+		}
+	}
+	if accBits > 0 {
+		payload = append(payload, byte(acc))
+	}
+	return payload
+}
+
+// Read a dictionary-coded column of byte values from a block payload into the
+// specified slice, which sets the count of values read, returning the rest of
+// the payload.
+func readDictColumn(payload []byte, values []byte) ([]byte, error) {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0])+1 {
+		return nil, errors.New("truncated dictionary")
+	}
+	dict := payload[1 : 2+int(payload[0])]
+	payload = payload[2+int(payload[0]):]
+
+	width := bits.Len(uint(len(dict) - 1))
+	size := (len(values)*width + 7) / 8
+	if len(payload) < size {
+		return nil, errors.New("truncated dictionary codes")
+	}
+	mask := uint(1)<<width - 1
+	var acc uint
+	accBits, j := 0, 0
+	for i := range values {
+		for accBits < width {
+			acc |= uint(payload[j]) << accBits
+			accBits += 8
+			j++
+		}
+		code := int(acc & mask)
+		if code >= len(dict) {
+			return nil, errors.New("dictionary code out of range")
+		}
+		values[i] = dict[code]
+		acc >>= width
+		accBits -= width
+	}
+	return payload[size:], nil
+}
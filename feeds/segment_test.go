@@ -0,0 +1,226 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"github.com/cparo/perspective"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompactBinLogFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	iPath, oPath := filepath.Join(dir, "log"), filepath.Join(dir, "seg")
+	want := extremeTestEvents()
+	appendTestEvents(t, iPath, want)
+	if err := CompactBinLogFile(iPath, oPath); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(oPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []byte{'P', 'R', 'S', 'C', 'L', 0, 0, 0, 1, 0, 0, 0, 0, 4, 0, 0}
+	if !bytes.HasPrefix(b, header) {
+		t.Errorf("segment header = %v, want %v", b[:segmentHeaderSize], header)
+	}
+
+	s := openTestSegment(t, oPath)
+	if s.Len() != int64(len(want)) {
+		t.Errorf("segment holds %d records, want %d", s.Len(), len(want))
+	}
+	got := readTestSegment(t, s, math.MinInt64, math.MaxInt64)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("segment holds %d events differing from the log's", len(got))
+	}
+	if !reflect.DeepEqual(s.index().blocks, indexEvents(want).blocks) {
+		t.Errorf("segment blocks do not line up with the log's index")
+	}
+
+	// Segments are read through MapBinLogFile as logs are.
+	events := MapBinLogFile(oPath, 0)
+	if events == nil || !reflect.DeepEqual(*events, want) {
+		t.Errorf("segment was not mapped as the log it was compacted from")
+	}
+	if events != nil {
+		UnmapBinLogFile(events)
+	}
+}
+
+func TestCompactV1BinLogFile(t *testing.T) {
+	iPath := writeTestFile(t, "log", v1TestRecords(v1TestEvents()))
+	if err := CompactBinLogFile(iPath, iPath); err != nil {
+		t.Fatal(err)
+	}
+	s := openTestSegment(t, iPath)
+	got := readTestSegment(t, s, math.MinInt64, math.MaxInt64)
+	if !reflect.DeepEqual(got, upgradedTestEvents()) {
+		t.Errorf("segment compacted in place holds %v", got)
+	}
+}
+
+func TestSegmentSkipsBlocksOutsideRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	appendTestEvents(t, path, orderedTestEvents(3*segmentBlockSize))
+	if err := CompactBinLogFile(path, path); err != nil {
+		t.Fatal(err)
+	}
+	s := openTestSegment(t, path)
+	got := readTestSegment(t, s, 11023, 11024)
+	want := orderedTestEvents(2 * segmentBlockSize)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %d events from range, want %d", len(got), len(want))
+	}
+}
+
+func TestSegmentChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	iPath, oPath := filepath.Join(dir, "log"), filepath.Join(dir, "seg")
+	all := testEvents(2*segmentBlockSize + 5)
+	appendTestEvents(t, iPath, all)
+	if err := CompactBinLogFile(iPath, oPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the last byte of the second block's payload.
+	s := openTestSegment(t, oPath)
+	offset := s.blocks[1].offset + s.blocks[1].size - 1
+	b, err := os.ReadFile(oPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[offset] ^= 0x40
+	if err := os.WriteFile(oPath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Iteration stops with an error after the first block.
+	s = openTestSegment(t, oPath)
+	it := s.Events(math.MinInt64, math.MaxInt64)
+	n := 0
+	for e := it.Next(); e != nil; e = it.Next() {
+		n++
+	}
+	if n != segmentBlockSize {
+		t.Errorf("read %d events before the corrupt block", n)
+	}
+	if it.Err() == nil || !strings.Contains(it.Err().Error(), "checksum") {
+		t.Errorf("iteration ended with error %v", it.Err())
+	}
+
+	// Reading the whole segment fails, rather than returning partial data.
+	if events := MapBinLogFile(oPath, 0); events != nil {
+		t.Errorf("corrupt segment was mapped with %d events", len(*events))
+		UnmapBinLogFile(events)
+	}
+
+	// Reading only the blocks before the corrupt one still succeeds.
+	events, _ := MapBinLogRange(
+		oPath, all[0].Start, all[0].Start, Lookback{})
+	if events == nil {
+		t.Errorf("blocks before the corrupt block were not read")
+	} else {
+		UnmapBinLogFile(events)
+	}
+}
+
+func TestOpenSegmentRejectsMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	appendTestEvents(t, path, testEvents(segmentBlockSize+1))
+	if err := CompactBinLogFile(path, path); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, mangle := range map[string]func([]byte) []byte{
+		"magic":      func(b []byte) []byte { b[3] = 'X'; return b },
+		"version":    func(b []byte) []byte { b[8]++; return b },
+		"block size": func(b []byte) []byte { b[12]++; return b },
+		"last block": func(b []byte) []byte { return b[:len(b)-1] },
+		"block count": func(b []byte) []byte {
+			b[segmentHeaderSize]--
+			return b
+		},
+		"block header": func(b []byte) []byte {
+			return b[:segmentHeaderSize+segmentBlockHeaderSize-1]
+		},
+	} {
+		b := mangle(append([]byte(nil), good...))
+		if _, err := OpenSegment(writeTestFile(t, "seg", b)); err == nil {
+			t.Errorf("segment with bad %s was opened", name)
+		}
+	}
+}
+
+// Get events with extreme field values, spanning several segment blocks and
+// ending in a partial block, with one block holding every possible type.
+func extremeTestEvents() []perspective.EventData {
+	events := testEvents(3*segmentBlockSize + 7)
+	for i := 0; i < 256; i++ {
+		events[segmentBlockSize+i].Type = uint8(i)
+	}
+	extremes := []perspective.EventData{
+		{ID: math.MinInt32, Type: 255, Status: math.MinInt8, Region: 255,
+			Progress: 255, Start: math.MinInt64, Run: 0},
+		{ID: math.MaxInt32, Type: 0, Status: math.MaxInt8, Region: 0,
+			Progress: 0, Start: math.MaxInt64, Run: -1},
+		{ID: 0, Type: 1, Status: -1, Region: 1,
+			Progress: 1, Start: 0, Run: math.MinInt64},
+		{ID: -1, Type: 2, Status: 0, Region: 2,
+			Progress: 2, Start: -1, Run: math.MaxInt64}}
+	copy(events[len(events)-len(extremes):], extremes)
+	return events
+}
+
+// Open a segment, closing it once the test is done.
+func openTestSegment(t *testing.T, path string) *Segment {
+	s, err := OpenSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Read the events of the blocks of a segment which could hold events in the
+// specified time range.
+func readTestSegment(
+	t *testing.T,
+	s *Segment,
+	tA int64,
+	tΩ int64) []perspective.EventData {
+
+	var events []perspective.EventData
+	it := s.Events(tA, tΩ)
+	for e := it.Next(); e != nil; e = it.Next() {
+		events = append(events, *e)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
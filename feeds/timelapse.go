@@ -0,0 +1,265 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cparo/perspective"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+)
+
+// Upper limit on the number of frames in a timelapse, to keep a careless choice
+// of window stride from tying up a server for hours.
+const MaxTimelapseFrames = 1000
+
+// Signature which begins every PNG file.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// VisualizerFactory returns a new visualization generator for the specified
+// time range, so a timelapse can render each of its frames with a fresh one.
+type VisualizerFactory func(minTime int, maxTime int) perspective.Visualizer
+
+// GenerateGIFTimelapseFromBinLog reads a binary-log formatted event-data dump
+// and renders a timelapse of a window of the specified width, in seconds,
+// stepped across the specified time range by the specified stride, as an
+// animated GIF. Each frame is rendered by a visualization generator from the
+// specified factory, with events recorded as they are for
+// GeneratePNGFromBinLog, and is shown for the specified delay in milliseconds.
+// Frame colors are mapped onto a fixed palette. An error is returned if the
+// window and stride would give no frames or too many frames, or if the output
+// could not be written.
+func GenerateGIFTimelapseFromBinLog(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	window int64,
+	stride int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	factory VisualizerFactory,
+	delay int,
+	workers int,
+	out io.Writer) error {
+
+	anim := &gif.GIF{}
+	err := renderTimelapse(
+		events, tA, tΩ, window, stride, typeFilter, regionFilter,
+		statusFilter, factory, workers,
+		func(frame image.Image) error {
+			b := frame.Bounds()
+			paletted := image.NewPaletted(b, palette.Plan9)
+			draw.Draw(paletted, b, frame, b.Min, draw.Src)
+			anim.Image = append(anim.Image, paletted)
+			anim.Delay = append(anim.Delay, delay/10)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return gif.EncodeAll(out, anim)
+}
+
+// GenerateAPNGTimelapseFromBinLog renders a timelapse as is done by
+// GenerateGIFTimelapseFromBinLog, but encodes it as an animated PNG. This keeps
+// the full color of each frame, and frames are written out as they are
+// rendered rather than being held in memory until the end.
+func GenerateAPNGTimelapseFromBinLog(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	window int64,
+	stride int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	factory VisualizerFactory,
+	delay int,
+	workers int,
+	out io.Writer) error {
+
+	frames, err := timelapseFrames(tA, tΩ, window, stride)
+	if err != nil {
+		return err
+	}
+
+	// Each frame is encoded as a standalone PNG and then taken apart into its
+	// chunks. The first frame's header and image data become the default image
+	// (which viewers without APNG support will show), and the image data of
+	// each later frame is renumbered into frame data chunks.
+	seq := uint32(0)
+	first := true
+	var buf bytes.Buffer
+	err = renderTimelapse(
+		events, tA, tΩ, window, stride, typeFilter, regionFilter,
+		statusFilter, factory, workers,
+		func(frame image.Image) error {
+			buf.Reset()
+			if err := png.Encode(&buf, frame); err != nil {
+				return err
+			}
+			chunks, err := pngChunks(buf.Bytes())
+			if err != nil {
+				return err
+			}
+			if first {
+				if _, err := out.Write(pngSignature); err != nil {
+					return err
+				}
+				actl := make([]byte, 8)
+				binary.BigEndian.PutUint32(actl[0:], uint32(frames))
+				err := writePNGChunk(out, "IHDR", chunks["IHDR"][0])
+				if err == nil {
+					err = writePNGChunk(out, "acTL", actl)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			b := frame.Bounds()
+			fctl := make([]byte, 26)
+			binary.BigEndian.PutUint32(fctl[0:], seq)
+			binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+			binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+			binary.BigEndian.PutUint16(fctl[20:], uint16(delay))
+			binary.BigEndian.PutUint16(fctl[22:], 1000)
+			seq++
+			if err := writePNGChunk(out, "fcTL", fctl); err != nil {
+				return err
+			}
+			for _, data := range chunks["IDAT"] {
+				if first {
+					err = writePNGChunk(out, "IDAT", data)
+				} else {
+					fdat := make([]byte, 4, 4+len(data))
+					binary.BigEndian.PutUint32(fdat, seq)
+					seq++
+					err = writePNGChunk(out, "fdAT", append(fdat, data...))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			first = false
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return writePNGChunk(out, "IEND", nil)
+}
+
+// Render each frame of a timelapse, handing the frames off in order to the
+// specified function.
+func renderTimelapse(
+	events *[]perspective.EventData,
+	tA int64,
+	tΩ int64,
+	window int64,
+	stride int64,
+	typeFilter int,
+	regionFilter int,
+	statusFilter int,
+	factory VisualizerFactory,
+	workers int,
+	emit func(image.Image) error) error {
+
+	frames, err := timelapseFrames(tA, tΩ, window, stride)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < frames; i++ {
+		t := tA + int64(i)*stride
+		v := factory(int(t), int(t+window))
+		RecordEvents(
+			events, t, t+window, typeFilter, regionFilter, statusFilter, v,
+			workers)
+		if err := emit(v.Render()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the number of frames in a timelapse of a window of the specified width
+// stepped across the specified time range, returning an error if there would be
+// no frames or too many frames.
+func timelapseFrames(
+	tA int64,
+	tΩ int64,
+	window int64,
+	stride int64) (int, error) {
+
+	if window <= 0 || stride <= 0 || tΩ-tA < window {
+		return 0, errors.New("timelapse window does not fit in time range")
+	}
+	frames := int((tΩ-tA-window)/stride) + 1
+	if frames > MaxTimelapseFrames {
+		return 0, fmt.Errorf(
+			"timelapse would have %d frames, more than the limit of %d",
+			frames,
+			MaxTimelapseFrames)
+	}
+	return frames, nil
+}
+
+// Split an encoded PNG image into the data of its chunks, grouped by chunk type
+// in the order they appear.
+func pngChunks(b []byte) (map[string][][]byte, error) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return nil, errors.New("malformed PNG signature")
+	}
+	chunks := make(map[string][][]byte)
+	for b = b[len(pngSignature):]; len(b) >= 12; {
+		n := int(binary.BigEndian.Uint32(b))
+		if n > len(b)-12 {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		t := string(b[4:8])
+		chunks[t] = append(chunks[t], b[8:8+n])
+		b = b[12+n:]
+	}
+	return chunks, nil
+}
+
+// Write a PNG chunk of the specified type and data.
+func writePNGChunk(out io.Writer, t string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], t)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
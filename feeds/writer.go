@@ -0,0 +1,243 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/cparo/perspective"
+	"os"
+	"sync"
+)
+
+// SyncPolicy selects when a Writer forces the records it has written out to
+// stable storage, trading the throughput of appends against how many of the
+// most recent records could be lost if the host crashes. Records are always
+// handed to the operating system when the writer is flushed, so they survive
+// the writing process itself crashing without any syncing at all.
+type SyncPolicy int
+
+const (
+	SyncNever   SyncPolicy = iota // Leave syncing to the operating system
+	SyncOnFlush                   // Sync each time the writer is flushed
+	SyncAlways                    // Flush and sync after every record
+)
+
+// Writer appends event records to a binary log, so that a feed can be built up
+// continuously as events complete rather than converted in a batch, keeping
+// the log's sidecar index up to date as it goes. Records are buffered, and are
+// only visible to readers of the log once the writer is flushed. A Writer is
+// safe for use by multiple goroutines.
+type Writer struct {
+	file      *os.File      // Binary log being appended to
+	buffer    *bufio.Writer // Buffer of records not yet written to the log
+	index     *binLogIndex  // Index of the records appended to the log
+	indexFile *os.File      // Sidecar file the index is written to
+	policy    SyncPolicy    // When to sync written records to stable storage
+	lock      sync.Mutex    // Lock serializing appends, flushes, and closing
+}
+
+// OpenWriter opens a binary log for appending, creating it with a header if it
+// does not exist. A partial record at the end of the log, as left by a writer
+// which crashed partway through writing it, is truncated away so that appended
+// records stay aligned, and the log's index is brought up to date with any
+// records it does not cover. An error is returned if the log or its index
+// could not be opened, or if the log is not a log of the current version of
+// the format, in which case it should be upgraded with UpgradeBinLogFile
+// before appending to it.
+func OpenWriter(path string, policy SyncPolicy) (*Writer, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.OpenFile(
+		BinLogIndexPath(path), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w := &Writer{
+		file,
+		bufio.NewWriter(file),
+		nil,
+		indexFile,
+		policy,
+		sync.Mutex{}}
+	if err := w.prepare(path); err != nil {
+		file.Close()
+		indexFile.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends an event record to the log.
+func (w *Writer) Write(e *perspective.EventData) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return errors.New("binary-log writer is closed")
+	}
+	if err := binary.Write(w.buffer, binary.LittleEndian, e); err != nil {
+		return err
+	}
+	w.index.add(e)
+	if w.policy == SyncAlways {
+		return w.flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered records out to the log, syncing them to stable
+// storage as well if the sync policy calls for it.
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return errors.New("binary-log writer is closed")
+	}
+	return w.flush()
+}
+
+// Close flushes any buffered records out to the log and closes it. The writer
+// must not be used afterward.
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return errors.New("binary-log writer is closed")
+	}
+	err := w.flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := w.indexFile.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
+
+// Write out any buffered records and then the index, syncing them unless the
+// policy is to leave syncing to the operating system. The records are synced
+// before the index is written, so that the index never covers records which
+// could be lost.
+func (w *Writer) flush() error {
+	if err := w.buffer.Flush(); err != nil {
+		return err
+	}
+	if w.policy != SyncNever {
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := w.index.write(w.indexFile); err != nil {
+		return err
+	}
+	if w.policy == SyncNever {
+		return nil
+	}
+	return w.indexFile.Sync()
+}
+
+// Ready a newly-opened log for appending, writing a header to it if it is new
+// (or if a crash left it with only part of one), or checking its header and
+// truncating any partial record from its end otherwise, and then ready its
+// index.
+func (w *Writer) prepare(path string) error {
+
+	stat, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	if size < binLogHeaderSize {
+		prefix := make([]byte, size)
+		if _, err := w.file.ReadAt(prefix, 0); err != nil {
+			return err
+		}
+		n := len(binLogMagic)
+		if len(prefix) < n {
+			n = len(prefix)
+		}
+		if !bytes.Equal(prefix[:n], binLogMagic[:n]) {
+			return errors.New("not a binary log of the current version")
+		}
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+		if err := w.indexFile.Truncate(0); err != nil {
+			return err
+		}
+		if err := writeBinLogHeader(w.buffer); err != nil {
+			return err
+		}
+		w.index = &binLogIndex{}
+		return w.flush()
+	}
+
+	header, err := readBinLogHeader(w.file)
+	if err != nil {
+		return err
+	}
+	if header == nil || header.version != BinLogVersion {
+		return errors.New("not a binary log of the current version")
+	}
+	if err := header.validate(); err != nil {
+		return err
+	}
+	recordSize := int64(header.recordSize)
+	if torn := (size - binLogHeaderSize) % recordSize; torn > 0 {
+		size -= torn
+		if err := w.file.Truncate(size); err != nil {
+			return err
+		}
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return w.prepareIndex(path, (size-binLogHeaderSize)/recordSize)
+}
+
+// Read the index of a log with the specified count of records, rebuilding it
+// if it is missing or malformed, and extending it to cover any records it
+// does not.
+func (w *Writer) prepareIndex(path string, records int64) error {
+	w.index = readBinLogIndex(BinLogIndexPath(path), records)
+	if w.index == nil {
+		if err := w.indexFile.Truncate(0); err != nil {
+			return err
+		}
+		w.index = &binLogIndex{}
+	}
+	if w.index.records < records {
+		events := MapBinLogFile(path, records-w.index.records)
+		if events == nil {
+			return errors.New("failed to read binary log for indexing")
+		}
+		for i := range *events {
+			w.index.add(&(*events)[i])
+		}
+		UnmapBinLogFile(events)
+	}
+	return w.flush()
+}
@@ -0,0 +1,131 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package feeds
+
+import (
+	"github.com/cparo/perspective"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	want := testEvents(2*binLogIndexBlockSize + 5)
+	appendTestEvents(t, path, want)
+	checkTestLog(t, path, want)
+}
+
+func TestOpenWriterTruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	want := testEvents(30)
+	appendTestEvents(t, path, want[:20])
+
+	// Leave part of a record at the end of the log, as a writer which crashed
+	// partway through writing it would.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+	file.Close()
+
+	// The partial record is not read, and is dropped once the log is opened
+	// for appending again, so that the records appended after it line up.
+	checkTestLog(t, path, want[:20])
+	appendTestEvents(t, path, want[20:])
+	checkTestLog(t, path, want)
+}
+
+func TestOpenWriterReplacesPartialHeader(t *testing.T) {
+	path := writeTestFile(t, "log", binLogMagic[:3])
+	want := testEvents(3)
+	appendTestEvents(t, path, want)
+	checkTestLog(t, path, want)
+}
+
+func TestOpenWriterRejectsOtherFiles(t *testing.T) {
+	for name, path := range map[string]string{
+		"short file": writeTestFile(t, "short", []byte("PRX")),
+		"legacy log": writeTestFile(t, "legacy", v1TestRecords(v1TestEvents())),
+		"v1 log":     writeTestFile(t, "v1", v1TestHeader()),
+	} {
+		if w, err := OpenWriter(path, SyncNever); err == nil {
+			w.Close()
+			t.Errorf("opened writer on %s", name)
+		}
+	}
+}
+
+// Get the specified count of events, with fields covering a range of values
+// and start times which are out of order.
+func testEvents(n int) []perspective.EventData {
+	events := make([]perspective.EventData, n)
+	for i := range events {
+		events[i] = perspective.EventData{
+			ID:       int32(i*7919 - 100),
+			Type:     uint8(i % 5),
+			Status:   int8(i%4 - 1),
+			Region:   uint8(i % 3),
+			Progress: uint8(i % 101),
+			Start:    1700000000 + int64(i*37%1000) + int64(i/10*10),
+			Run:      int64(i%97)*perspective.RunTimeUnitsPerSecond + int64(i)}
+	}
+	return events
+}
+
+// Append the specified events to the log at the specified path.
+func appendTestEvents(
+	t *testing.T,
+	path string,
+	events []perspective.EventData) {
+
+	w, err := OpenWriter(path, SyncOnFlush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		if err := w.Write(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Check that the log at the specified path holds exactly the specified events,
+// and that its index covers them.
+func checkTestLog(t *testing.T, path string, want []perspective.EventData) {
+	t.Helper()
+	events := MapBinLogFile(path, 0)
+	if events == nil {
+		t.Fatal("log was not read")
+	}
+	defer UnmapBinLogFile(events)
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("log holds %d events, want %d", len(*events), len(want))
+	}
+	index := readBinLogIndex(BinLogIndexPath(path), int64(len(want)))
+	if index == nil ||
+		!reflect.DeepEqual(index.blocks, indexEvents(want).blocks) {
+
+		t.Errorf("log has index %+v", index)
+	}
+}
@@ -20,28 +20,42 @@ package perspective
 import (
 	"image"
 	"image/color"
+	"io"
 	"math"
-	"math/rand"
 )
 
 type histogram struct {
-	w     int     // Width of the visualization
-	h     int     // Height of the visualization
-	bg    int     // Background grey level
-	yLog2 float64 // Number of pixels over which elapsed times double
-	pass  []int   // Counts of successful events by x-axis position
-	fail  []int   // Counts of failed events by x-axis position
+	w      int     // Width of the visualization
+	h      int     // Height of the visualization
+	theme  *Theme  // Colors to render the visualization with
+	yLog2  float64 // Number of pixels over which elapsed times double
+	tMin   float64 // Run time at the origin of the run-time axis
+	pass   []int   // Counts of successful events by x-axis position
+	fail   []int   // Counts of failed events by x-axis position
+	labels bool    // Whether to draw axis labels and a legend
+	jitter *Jitter // Source of random noise applied to run times
 }
 
 // NewHistogram returns a histogram-visualization generator.
-func NewHistogram(width int, height int, bg int, yLog2 float64) Visualizer {
+func NewHistogram(
+	width int,
+	height int,
+	theme *Theme,
+	yLog2 float64,
+	minRunTime float64,
+	labels bool,
+	jitter *Jitter) Visualizer {
+
 	return &histogram{
 		width,
 		height,
-		bg,
+		themeOrDefault(theme),
 		yLog2,
+		minRunTime,
+		make([]int, width),
 		make([]int, width),
-		make([]int, width)}
+		labels,
+		jitter}
 }
 
 // Record accepts an EventData pointer and plots it onto the visualization.
@@ -52,12 +66,12 @@ func (v *histogram) Record(e *EventData) {
 	// and quantization artifacts which could distract from real patterns or
 	// create a false sense of consistency in the run times of short-lived
 	// events.
-	t := float64(e.Run) + rand.NormFloat64() / 2
+	t := e.RunTime() + v.jitter.offset(e)
 
-	// Run time is hacked to a floor of 1 because a log of zero doesn't
-	// make a lot of sense, and there are some fun cases of events with
-	// negative recorded run times because of clock skew.
-	x := int(v.yLog2 * math.Log2(math.Max(1, t)))
+	// Run time is hacked to a floor at the origin of the axis because a log
+	// of zero doesn't make a lot of sense, and there are some fun cases of
+	// events with negative recorded run times because of clock skew.
+	x := int(v.yLog2 * runTimeLog2(math.Max(v.tMin, t), v.tMin))
 
 	// Discard data which lies beyond the specified bounds for the
 	// rendered visualization, and only record completed events. Incomplete
@@ -71,45 +85,157 @@ func (v *histogram) Record(e *EventData) {
 	}
 }
 
+// Clone returns an empty histogram-visualization generator with the same
+// configuration as this one.
+func (v *histogram) Clone() Visualizer {
+	c := *v
+	c.pass = make([]int, len(v.pass))
+	c.fail = make([]int, len(v.fail))
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *histogram) Merge(o Visualizer) {
+	other := o.(*histogram)
+	addInts(v.pass, other.pass)
+	addInts(v.fail, other.fail)
+}
+
 // Render returns the visualization constructed from all previously-recorded
 // data points.
 func (v *histogram) Render() image.Image {
 
 	// Initialize our image canvas and grid.
-	vis := initializeVisualization(v.w, v.h, v.bg)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
 	v.drawGrid(vis)
 
-	// Find the highest point of the histogram to normalize the height of the
-	// masts.
-	maxCount := float64(0)
-	for x := 0; x < v.w; x++ {
-		maxCount = math.Max(maxCount, float64(v.pass[x]+v.fail[x]))
-	}
-	scale := float64(v.h) / maxCount
-
-	// Set up our pass and fail colors.
-	passColor := color.RGBA{83, 83, 191, 255}
-	failColor := color.RGBA{191, 33, 33, 255}
-
 	// Draw the masts, with successes stacked atop failures.
+	scale := v.scale()
 	for x := 0; x < v.w; x++ {
 		fail := int(math.Ceil(float64(v.fail[x]) * scale))
 		pass := int(math.Ceil(float64(v.pass[x]) * scale))
 		for y := 0; y < fail; y++ {
-			vis.Set(x, v.h-y, failColor)
+			vis.Set(x, v.h-y, v.theme.Failure)
 		}
 		for y := fail; y < fail+pass; y++ {
-			vis.Set(x, v.h-y, passColor)
+			vis.Set(x, v.h-y, v.theme.Success)
 		}
 	}
 
+	// Label the run-time axis and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
 	return vis
 }
 
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *histogram) RenderSVG(out io.Writer) error {
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Draw vertical grid lines on each doubling of the run time in seconds.
+	for x := v.yLog2; x < float64(v.w); x += v.yLog2 {
+		svg.xGridLine(int(x))
+	}
+
+	// Draw the masts, with successes stacked atop failures.
+	scale := v.scale()
+	for x := 0; x < v.w; x++ {
+		fail := math.Ceil(float64(v.fail[x]) * scale)
+		pass := math.Ceil(float64(v.pass[x]) * scale)
+		svg.rect(
+			float64(x), float64(v.h)-fail+1, 1, fail, v.theme.Failure)
+		svg.rect(
+			float64(x), float64(v.h)-fail-pass+1, 1, pass, v.theme.Success)
+	}
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// RenderText writes the visualization constructed from all previously-recorded
+// data points out as colored sparklines for display in a terminal, with one
+// character for each pixel of the visualization's width. Successes are drawn
+// above failures, on the same scale.
+func (v *histogram) RenderText(out io.Writer) error {
+
+	text := newTextWriter(out, v.w)
+
+	pass, fail := make([]float64, v.w), make([]float64, v.w)
+	maxCount := 0.0
+	for x := 0; x < v.w; x++ {
+		pass[x], fail[x] = float64(v.pass[x]), float64(v.fail[x])
+		maxCount = math.Max(maxCount, math.Max(pass[x], fail[x]))
+	}
+	rows := intMax(1, v.h/(2*cellHeight))
+	scale := float64(rows*cellHeight) / math.Max(maxCount, 1)
+	for _, mast := range []struct {
+		counts []float64
+		c      color.RGBA
+	}{
+		{pass, v.theme.Success},
+		{fail, v.theme.Failure},
+	} {
+		c := mast.c
+		text.sparkline(
+			mast.counts, scale, rows, func(int) color.RGBA { return c })
+	}
+
+	if v.labels {
+		text.axis(runTimeLabelsX(v.w, v.h, v.yLog2, v.tMin))
+		text.legend([]legendEntry{
+			{v.theme.Success, "success"},
+			{v.theme.Failure, "failure"}})
+	}
+
+	return text.close()
+}
+
+// Data returns the counts of successful and failed events in each run-time bin
+// of the histogram.
+func (v *histogram) Data() *SeriesData {
+	return &SeriesData{
+		XAxis: RunTimeAxis,
+		X:     runTimeCoordinates(v.w, v.yLog2, v.tMin),
+		Series: map[string][]float64{
+			"success": float64s(v.pass),
+			"failure": float64s(v.fail)}}
+}
+
+// Get the labels for the run-time axis, and the color legend.
+func (v *histogram) axisLabels() ([]label, []legendEntry) {
+	legend := []legendEntry{
+		{v.theme.Success, "success"},
+		{v.theme.Failure, "failure"}}
+	labels := runTimeLabelsX(v.w, v.h, v.yLog2, v.tMin)
+	return labelsLeftOf(labels, legendLeft(v.w, legend)), legend
+}
+
+// Find the highest point of the histogram to get the scale factor which will
+// normalize the height of the masts.
+func (v *histogram) scale() float64 {
+	maxCount := float64(0)
+	for x := 0; x < v.w; x++ {
+		maxCount = math.Max(maxCount, float64(v.pass[x]+v.fail[x]))
+	}
+	return float64(v.h) / math.Max(1, maxCount)
+}
+
 func (v *histogram) drawGrid(vis *image.RGBA) {
 
 	// Draw vertical grid lines on each doubling of the run time in seconds.
 	for x := v.yLog2; x < float64(v.w); x += v.yLog2 {
-		drawXGridLine(vis, int(x))
+		drawXGridLine(vis, int(x), v.theme.Grid)
 	}
 }
@@ -0,0 +1,98 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Jitter is a source of the random "noise" which some visualizations apply to
+// event run times to avoid Moire patterns and quantization artifacts. Each
+// visualization generator should be given its own Jitter, so that rendering
+// the same data with the same seed gives the same pixels every time. A nil
+// Jitter draws from the global (unseeded) random number source instead.
+type Jitter struct {
+	seed    int64      // Seed value for the jitter source
+	byEvent bool       // Whether jitter is derived from event IDs
+	rng     *rand.Rand // Pseudo-random number source (when not by event)
+	clones  int64      // Number of clones taken of the jitter source
+}
+
+// NewJitter returns a jitter source seeded with the specified value. If
+// byEventID is set, the jitter for each event is derived from a hash of its ID
+// (mixed with the seed) instead of from a sequence of pseudo-random numbers, so
+// a given event always lands in the same place regardless of what other events
+// are recorded with it or what order they are recorded in.
+func NewJitter(seed int64, byEventID bool) *Jitter {
+	j := &Jitter{seed: seed, byEvent: byEventID}
+	if !byEventID {
+		j.rng = rand.New(rand.NewSource(seed))
+	}
+	return j
+}
+
+// Get a Gaussian-distributed offset with a standard deviation of 0.5 for the
+// specified event. Only run times recorded in whole seconds are quantized
+// enough to need jitter, so the offset is zero for those recorded more finely,
+// which would otherwise be blurred beyond recognition.
+func (j *Jitter) offset(e *EventData) float64 {
+	if e.Run%RunTimeUnitsPerSecond != 0 {
+		return 0
+	}
+	if j == nil {
+		return rand.NormFloat64() / 2
+	}
+	if j.rng != nil {
+		return j.rng.NormFloat64() / 2
+	}
+
+	// Use a Box-Muller transform of two uniformly-distributed values taken
+	// from successive steps of a SplitMix64 generator seeded by the event ID.
+	x := uint64(e.ID) ^ uint64(j.seed)*0x9e3779b97f4a7c15
+	x, u1 := splitMix64(x)
+	_, u2 := splitMix64(x)
+	r := math.Sqrt(-2 * math.Log(1-u1))
+	return r * math.Cos(2*math.Pi*u2) / 2
+}
+
+// Get a jitter source for a clone of a visualization generator. Hashed jitter
+// can simply be shared, while sequential jitter is given a stream of its own,
+// seeded by this source's seed plus the number of clones taken before it. The
+// streams depend only on the order of the clones, so generators cloned once
+// for each of a fixed set of shards of the events get the same jitter however
+// many of the shards are recorded at once, and the first clone draws the same
+// stream as recording into the original generator would.
+func (j *Jitter) clone() *Jitter {
+	if j == nil || j.rng == nil {
+		return j
+	}
+	j.clones++
+	return NewJitter(j.seed+j.clones-1, false)
+}
+
+// Advance a SplitMix64 generator state, returning the new state along with a
+// value derived from it which is uniformly distributed over [0, 1).
+func splitMix64(x uint64) (uint64, float64) {
+	x += 0x9e3779b97f4a7c15
+	z := x
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z = z ^ (z >> 31)
+	return x, float64(z>>11) / (1 << 53)
+}
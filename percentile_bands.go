@@ -0,0 +1,293 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Percentiles drawn by the percentile-band visualization. The bands span from
+// each percentile to the next, with the first percentile drawn as a line.
+var bandPercentiles = [3]float64{0.50, 0.90, 0.99}
+
+type percentileBands struct {
+	w        int              // Width of the visualization
+	h        int              // Height of the visualization
+	tA       float64          // Lower limit of time range to be visualized
+	tτ       float64          // Length of time range to be visualized
+	yLog2    float64          // Number of pixels over which run times double
+	tMin     float64          // Run time at the origin of the run-time axis
+	sketches []quantileSketch // Run-time quantile sketches by x-axis position
+	xGrid    int              // Number of vertical grid divisions
+	theme    *Theme           // Colors to render the visualization with
+	labels   bool             // Whether to draw axis labels and a legend
+	notes    []Annotation     // Annotations to draw over the time axis
+}
+
+// NewPercentileBands returns a run-time-percentile-band-visualization
+// generator, which shows the median, 90th-percentile, and 99th-percentile run
+// times of events over time.
+func NewPercentileBands(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	yLog2 float64,
+	minRunTime float64,
+	xGrid int,
+	labels bool) Visualizer {
+
+	return &percentileBands{
+		width,
+		height,
+		float64(minTime),
+		float64(maxTime - minTime),
+		yLog2,
+		minRunTime,
+		make([]quantileSketch, width),
+		xGrid,
+		themeOrDefault(theme),
+		labels,
+		nil}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *percentileBands) Record(e *EventData) {
+
+	// Position on the x-axis corresponds to the event's start time.
+	x := int(float64(v.w) * (float64(e.Start) - v.tA) / v.tτ)
+	if x < 0 || x >= v.w {
+		return
+	}
+
+	v.sketches[x].add(e.RunTime())
+}
+
+// Clone returns an empty percentile-band-visualization generator with the same
+// configuration as this one.
+func (v *percentileBands) Clone() Visualizer {
+	c := *v
+	c.sketches = make([]quantileSketch, len(v.sketches))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *percentileBands) Merge(o Visualizer) {
+	other := o.(*percentileBands)
+	for x := range v.sketches {
+		v.sketches[x].merge(&other.sketches[x])
+	}
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *percentileBands) Annotate(notes []Annotation) {
+	v.notes = notes
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *percentileBands) Render() image.Image {
+
+	// Initialize our image canvas and grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.drawGrid(vis)
+
+	// Draw the bands from the outermost inward, so the median line is drawn on
+	// top of the shaded regions, filling each column with the color of the
+	// band spanning from its percentile to the next one out.
+	colors := v.bandColors()
+	for x := 0; x < v.w; x++ {
+		if v.sketches[x].n == 0 {
+			continue
+		}
+		y := v.percentileYs(x)
+		for i := len(y) - 1; i > 0; i-- {
+			for yPos := y[i-1]; yPos <= y[i]; yPos++ {
+				*getRGBA(vis, x, v.h-yPos) = colors[i]
+			}
+		}
+		for yPos := y[0] - 1; yPos <= y[0]+1; yPos++ {
+			*getRGBA(vis, x, v.h-yPos) = colors[0]
+		}
+	}
+
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *percentileBands) RenderSVG(out io.Writer) error {
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Draw vertical grid lines, if vertical divisions were specified.
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+
+	// Draw horizontal grid lines on each doubling of the run time in seconds.
+	for y := float64(v.h); y > 0; y -= v.yLog2 {
+		svg.yGridLine(int(y))
+	}
+
+	// Trace each band and the median line along every contiguous run of
+	// x-positions which have recorded data.
+	colors := v.bandColors()
+	for x := 0; x < v.w; x++ {
+		if v.sketches[x].n == 0 {
+			continue
+		}
+		var ys [][len(bandPercentiles)]int
+		x0 := x
+		for ; x < v.w && v.sketches[x].n > 0; x++ {
+			ys = append(ys, v.percentileYs(x))
+		}
+		for i := len(bandPercentiles) - 1; i > 0; i-- {
+			var points []float64
+			for j := range ys {
+				points = append(
+					points, float64(x0+j), float64(v.h-ys[j][i]))
+				points = append(
+					points, float64(x0+j+1), float64(v.h-ys[j][i]))
+			}
+			for j := len(ys) - 1; j >= 0; j-- {
+				points = append(
+					points, float64(x0+j+1), float64(v.h-ys[j][i-1]+1))
+				points = append(
+					points, float64(x0+j), float64(v.h-ys[j][i-1]+1))
+			}
+			svg.polygon(points, colors[i])
+		}
+		var points []float64
+		for j := range ys {
+			points = append(
+				points, float64(x0+j)+0.5, float64(v.h-ys[j][0])+0.5)
+		}
+		if len(points) == 2 {
+			points = append(points, points[0]+0.5, points[1])
+		}
+		svg.polyline(points, colors[0], 3)
+	}
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the estimated run time in seconds at each of the band
+// percentiles for each x-position, along with the count of events the
+// estimates were drawn from.
+func (v *percentileBands) Data() *SeriesData {
+	counts := make([]float64, v.w)
+	var bands [len(bandPercentiles)][]float64
+	for i := range bands {
+		bands[i] = make([]float64, v.w)
+	}
+	for x := range v.sketches {
+		counts[x] = float64(v.sketches[x].n)
+		for i, p := range bandPercentiles {
+			if counts[x] > 0 {
+				bands[i][x] = v.sketches[x].quantile(p)
+			}
+		}
+	}
+	series := map[string][]float64{"count": counts}
+	for i, p := range bandPercentiles {
+		series[fmt.Sprintf("p%.0f", p*100)] = bands[i]
+	}
+	return &SeriesData{
+		XAxis:  TimeAxis,
+		X:      timeCoordinates(v.w, v.tA, v.tτ),
+		Series: series}
+}
+
+// Get the heights of the lines representing each of the band percentiles at
+// the specified x-position. We only calculate logs on source time values which
+// exceed the run time at the origin of the axis in order to put a floor value
+// of zero on the output value.
+func (v *percentileBands) percentileYs(x int) [len(bandPercentiles)]int {
+	var ys [len(bandPercentiles)]int
+	for i, p := range bandPercentiles {
+		if t := v.sketches[x].quantile(p); t > v.tMin {
+			ys[i] = int(v.yLog2 * runTimeLog2(t, v.tMin))
+		}
+	}
+	return ys
+}
+
+// Get the colors for the median line and for the bands out to each further
+// percentile, with the bands growing dimmer as they reach further into the
+// tail of the distribution.
+func (v *percentileBands) bandColors() [len(bandPercentiles)]color.RGBA {
+	bg, c := v.theme.Background, v.theme.Success
+	return [len(bandPercentiles)]color.RGBA{
+		c,
+		blend(bg, c, 0.6),
+		blend(bg, c, 0.3)}
+}
+
+// Get the labels for the time and run-time axes, and the color legend.
+func (v *percentileBands) axisLabels() ([]label, []legendEntry) {
+	labels := timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ)
+	labels = append(labels, runTimeLabels(v.h, v.yLog2, v.tMin)...)
+	colors := v.bandColors()
+	legend := []legendEntry{
+		{colors[0], "p50"},
+		{colors[1], "p50-p90"},
+		{colors[2], "p90-p99"}}
+	return labels, legend
+}
+
+func (v *percentileBands) drawGrid(vis *image.RGBA) {
+
+	// Draw vertical grid lines, if vertical divisions were specified.
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
+		}
+	}
+
+	// Draw horizontal grid lines on each doubling of the run time in seconds.
+	for y := float64(v.h); y > 0; y -= v.yLog2 {
+		drawYGridLine(vis, int(y), v.theme.Grid)
+	}
+}
@@ -23,12 +23,20 @@ import (
 	"github.com/cparo/perspective/feeds"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
 // Mapping of action names to handler functions:
 var handlers = make(map[string]func())
 
+// Mapping of visualization names to factories for the visualization generators
+// which can be animated as timelapses:
+var timelapses = make(map[string]feeds.VisualizerFactory)
+
 // Command-line options and arguments:
 var (
 	errorClassConf string  // Optional conf file for error classification.
@@ -41,15 +49,44 @@ var (
 	pτ             int     // The interval length for periodic visualizations.
 	xGrid          int     // Number of horizontal grid divisions.
 	yLog2          float64 // Number of pixels over which elapsed times double.
+	minRunTime     float64 // Run time at the origin of run-time axes.
+	maxRunTime     string  // Longest run time expected of an event, like "1d".
 	w              int     // Visualization width, in pixels.
 	h              int     // Visualization height, in pixels.
-	bg             int     // Graph background color.
+	bg             int     // Graph background gray level, if non-negative.
 	colors         float64 // The number of color steps before saturation.
 	resonance      float64 // Resonance value for line-smoothing.
+	format         string  // Output format for visualizations.
+	labels         bool    // Whether to draw axis labels and legends.
+	workers        int     // Number of goroutines to record events with.
+	seed           int64   // Seed for random jitter applied to run times.
+	jitterMode     string  // Source of jitter (random or event-id).
+	themeName      string  // Name of the color theme for visualizations.
+	stuckProgress  int     // Progress percentage below which events may stick.
+	stuckRunTime   int     // Run time in seconds after which events may stick.
+	compareFeed    string  // Filesystem path for baseline input to compare to.
+	cA             int     // Lower limit of baseline time range to compare to.
+	cΩ             int     // Upper limit of baseline time range to compare to.
+	compareFails   bool    // Whether to compare only failed events.
+	byClass        bool    // Whether to split failures by error class.
+	percentiles    string  // Run-time percentiles to estimate, comma-separated.
+	groupBy        string  // Fields to group success rates by.
+	sortBy         string  // Order of success-rate groups (rate or volume).
+	timeZone       string  // Time zone in which calendar days are reckoned.
+	colorBy        string  // Shading of calendar days (rate or failures).
+	violin         bool    // Whether to draw box plots as violins.
+	sloTarget      float64 // Target success ratio of an SLO.
+	sloWindow      string  // Trailing window of an SLO, like "30d".
+	burnWindows    string  // Windows to report burn rates over, like "1h,1d".
+	notesPath      string  // Filesystem path for annotations to draw.
+	window         int     // Width of the sliding window of a timelapse.
+	stride         int     // Step between the frames of a timelapse.
+	frameDelay     int     // Time to show each frame of a timelapse, in ms.
+	animate        string  // Name of the visualization to animate.
 	action         string  // Indication of action to be taken.
 	iPath          string  // Filesystem path for input.
 	oPath          string  // Filesystem path for output.
-	lookback       int     // Events to look back through in feed (0 for all).
+	lookbackSpec   string  // Events or time span to look back through in feed.
 )
 
 func init() {
@@ -58,39 +95,180 @@ func init() {
 		feeds.ConvertCSVToBinary(
 			iPath,
 			oPath,
-			int32(tA),
-			int32(tΩ),
+			int64(tA),
+			int64(tΩ),
 			typeFilter,
 			regionFilter,
 			statusFilter,
 			errorClassConf)
 	}
 
+	handlers["binlog-upgrade"] = func() {
+		err := feeds.UpgradeBinLogFile(iPath, oPath)
+		if err != nil {
+			log.Println("Failed to upgrade binary log.")
+			log.Fatalln(err)
+		}
+	}
+
+	handlers["compact"] = func() {
+		err := feeds.CompactBinLogFile(iPath, oPath)
+		if err != nil {
+			log.Println("Failed to compact binary log.")
+			log.Fatalln(err)
+		}
+	}
+
+	handlers["vis-box-plot"] = func() {
+		if groupBy != "type" && groupBy != "region" {
+			log.Fatalln("Unrecognized box-plot grouping.")
+		}
+		visualize(
+			perspective.NewBoxPlot(
+				w, h, theme(), yLog2, minRunTime, groupBy == "region", violin,
+				labels, jitter()))
+	}
+
+	handlers["vis-calendar"] = func() {
+		visualize(calendar())
+	}
+
+	handlers["vis-concurrency"] = func() {
+		v := perspective.NewConcurrency(w, h, theme(), tA, tΩ, xGrid, labels)
+
+		// Events which started before the time range may still be running in
+		// it, so those which started up to the longest run time expected of an
+		// event before it must be recorded as well.
+		tA -= runTimeLimit()
+		visualize(v)
+	}
+
 	handlers["vis-count-lines"] = func() {
 		visualize(
-			perspective.NewCountLines(w, h, bg, tA, tΩ, resonance, xGrid))
+			perspective.NewCountLines(
+				w, h, theme(), tA, tΩ, resonance, xGrid, labels))
+	}
+
+	handlers["slo-status"] = func() {
+		reportSLO(slo())
+	}
+
+	handlers["vis-error-budget"] = func() {
+		s := slo()
+		v := perspective.NewErrorBudget(
+			w, h, theme(), tA, tΩ, s, xGrid, labels)
+
+		// Events over the SLO window before the time range count toward the
+		// budget at its start, so they must be recorded as well.
+		tA -= s.Window
+		visualize(v)
+	}
+
+	handlers["vis-error-stack"] = func() {
+		visualize(
+			perspective.NewErrorStack(
+				w, h, theme(), tA, tΩ, resonance, xGrid, labels))
 	}
 
 	handlers["vis-histogram"] = func() {
-		visualize(perspective.NewHistogram(w, h, bg, yLog2))
+		visualize(
+			perspective.NewHistogram(
+				w, h, theme(), yLog2, minRunTime, labels, jitter()))
+	}
+
+	handlers["vis-histogram-compare"] = func() {
+		compare(
+			perspective.NewHistogramComparison(
+				w, h, theme(), yLog2, minRunTime, compareFails, labels,
+				jitter()))
+	}
+
+	handlers["vis-percentile-bands"] = func() {
+		visualize(
+			perspective.NewPercentileBands(
+				w, h, theme(), tA, tΩ, yLog2, minRunTime, xGrid, labels))
 	}
 
 	handlers["vis-polar-scatter"] = func() {
 		visualize(
 			perspective.NewPolarScatter(
-				w, h, bg, tA, tΩ, p0, pτ, yLog2, colors))
+				w, h, theme(), tA, tΩ, p0, pτ, yLog2, minRunTime, colors,
+				byClass, labels, jitter()))
+	}
+
+	handlers["vis-progress"] = func() {
+		visualize(
+			perspective.NewProgress(
+				w, h, theme(), yLog2, minRunTime, colors, stuckProgress,
+				stuckRunTime, labels, jitter()))
+	}
+
+	handlers["survival-percentiles"] = func() {
+		v := perspective.NewSurvival(
+			w, h, theme(), yLog2, minRunTime, labels, jitter())
+		estimatePercentiles(v.(perspective.PercentileEstimator))
 	}
 
 	handlers["vis-run-time-line"] = func() {
 		visualize(
 			perspective.NewRunTimeLine(
-				w, h, bg, tA, tΩ, yLog2, xGrid))
+				w, h, theme(), tA, tΩ, yLog2, minRunTime, xGrid, labels))
 	}
 
 	handlers["vis-scatter"] = func() {
 		visualize(
 			perspective.NewScatter(
-				w, h, bg, tA, tΩ, yLog2, colors, xGrid))
+				w, h, theme(), tA, tΩ, yLog2, minRunTime, colors, xGrid,
+				byClass, labels, jitter()))
+	}
+
+	handlers["vis-scatter-compare"] = func() {
+		compare(
+			perspective.NewScatterComparison(
+				w, h, theme(), tA, tΩ, cA, cΩ, yLog2, minRunTime, colors,
+				xGrid, compareFails, labels, jitter()))
+	}
+
+	handlers["success-rates"] = func() {
+		breakDownSuccessRates(successRateBreakdown())
+	}
+
+	handlers["vis-success-rates"] = func() {
+		visualize(successRateBreakdown())
+	}
+
+	handlers["vis-survival"] = func() {
+		visualize(
+			perspective.NewSurvival(
+				w, h, theme(), yLog2, minRunTime, labels, jitter()))
+	}
+
+	handlers["timelapse"] = func() {
+		if factory, exists := timelapses[animate]; exists {
+			timelapse(factory)
+		} else {
+			log.Fatalln("Unrecognized visualization for timelapse.")
+		}
+	}
+
+	timelapses["vis-histogram"] = func(tA int, tΩ int) perspective.Visualizer {
+		return perspective.NewHistogram(
+			w, h, theme(), yLog2, minRunTime, labels, jitter())
+	}
+
+	timelapses["vis-polar-scatter"] = func(
+		tA int,
+		tΩ int) perspective.Visualizer {
+
+		return perspective.NewPolarScatter(
+			w, h, theme(), tA, tΩ, p0, pτ, yLog2, minRunTime, colors, byClass,
+			labels, jitter())
+	}
+
+	timelapses["vis-scatter"] = func(tA int, tΩ int) perspective.Visualizer {
+		return perspective.NewScatter(
+			w, h, theme(), tA, tΩ, yLog2, minRunTime, colors, xGrid, byClass,
+			labels, jitter())
 	}
 }
 
@@ -156,6 +334,18 @@ func main() {
 		16,
 		"Pixels along y-axis for every doubling in seconds of run time.")
 
+	flag.Float64Var(
+		&minRunTime,
+		"min-run-time",
+		1,
+		"Run time in seconds at the origin of run-time axes.")
+
+	flag.StringVar(
+		&maxRunTime,
+		"max-run-time",
+		"1d",
+		"Longest run time of events counted as running before the time range.")
+
 	flag.IntVar(
 		&w,
 		"width",
@@ -171,8 +361,8 @@ func main() {
 	flag.IntVar(
 		&bg,
 		"bg",
-		32,
-		"Background gray level.")
+		-1,
+		"Background gray level, overriding the theme's background.")
 
 	flag.Float64Var(
 		&colors,
@@ -186,11 +376,175 @@ func main() {
 		0.85,
 		"Resonance value for line-smoothin.")
 
-	flag.IntVar(
-		&lookback,
+	flag.StringVar(
+		&lookbackSpec,
 		"lookback",
+		"0",
+		"Events to scan from end of log, or a time span like 6h (0 for all).")
+
+	flag.StringVar(
+		&format,
+		"format",
+		"png",
+		"Output format for visualizations: png, svg, text, or json "+
+			"(except polar scatter). "+
+			"Scatter point clouds stay raster images within svg.")
+
+	flag.BoolVar(
+		&labels,
+		"labels",
+		true,
+		"Draw axis labels and a color legend onto visualizations.")
+
+	flag.IntVar(
+		&workers,
+		"workers",
+		runtime.NumCPU(),
+		"Number of goroutines to record events with.")
+
+	flag.Int64Var(
+		&seed,
+		"seed",
 		0,
-		"Number of events to scan, from end of log (or 0 for all events).")
+		"Seed for the random jitter applied to event run times.")
+
+	flag.StringVar(
+		&jitterMode,
+		"jitter",
+		"random",
+		"Jitter source: random (seeded) or event-id (hash of event ID).")
+
+	flag.StringVar(
+		&themeName,
+		"theme",
+		"dark",
+		"Color theme: dark, light, colorblind, or colorblind-light.")
+
+	flag.IntVar(
+		&stuckProgress,
+		"stuck-progress",
+		10,
+		"Progress percentage below which long-running events are stuck.")
+
+	flag.IntVar(
+		&stuckRunTime,
+		"stuck-run-time",
+		3600,
+		"Run time in seconds after which low-progress events are stuck.")
+
+	flag.StringVar(
+		&percentiles,
+		"percentiles",
+		"50,90,99",
+		"Run-time percentiles to estimate, comma-separated.")
+
+	flag.StringVar(
+		&groupBy,
+		"group-by",
+		"type",
+		"Grouping of success rates (type, region, or both) or box plots.")
+
+	flag.StringVar(
+		&sortBy,
+		"sort-by",
+		"rate",
+		"Order of success-rate groups: rate or volume.")
+
+	flag.BoolVar(
+		&violin,
+		"violin",
+		false,
+		"Draw box plots as violins, outlining the density of run times.")
+
+	flag.StringVar(
+		&timeZone,
+		"time-zone",
+		"Local",
+		"Time zone for calendar days, like UTC or America/New_York.")
+
+	flag.StringVar(
+		&colorBy,
+		"color-by",
+		"rate",
+		"Shading of calendar days: rate or failures.")
+
+	flag.Float64Var(
+		&sloTarget,
+		"slo-target",
+		0.999,
+		"Target ratio of successful events to completed events for an SLO.")
+
+	flag.StringVar(
+		&sloWindow,
+		"slo-window",
+		"30d",
+		"Trailing window of an SLO, in seconds or with an m, h, d, or w unit.")
+
+	flag.StringVar(
+		&burnWindows,
+		"burn-windows",
+		"1h,6h,1d,3d",
+		"Windows to report SLO burn rates over, comma-separated.")
+
+	flag.StringVar(
+		&compareFeed,
+		"compare-feed",
+		"",
+		"Baseline input to compare against (or empty for the same input).")
+
+	flag.IntVar(
+		&cA,
+		"compare-min-time",
+		-1,
+		"Least recent baseline time to compare to, or -1 for preceding range.")
+
+	flag.IntVar(
+		&cΩ,
+		"compare-max-time",
+		-1,
+		"Most recent baseline time to compare to, or -1 for preceding range.")
+
+	flag.BoolVar(
+		&compareFails,
+		"compare-failures",
+		false,
+		"Compare only the density of failed events.")
+
+	flag.BoolVar(
+		&byClass,
+		"by-error-class",
+		false,
+		"Plot failures in a distinct hue for each error class.")
+
+	flag.StringVar(
+		&notesPath,
+		"annotations",
+		"",
+		"JSON file of annotations to mark on time-axis visualizations.")
+
+	flag.IntVar(
+		&window,
+		"window",
+		3600,
+		"Width in seconds of the sliding window shown by a timelapse.")
+
+	flag.IntVar(
+		&stride,
+		"stride",
+		600,
+		"Step in seconds between the windows of a timelapse's frames.")
+
+	flag.IntVar(
+		&frameDelay,
+		"frame-delay",
+		100,
+		"Time in milliseconds to show each frame of a timelapse.")
+
+	flag.StringVar(
+		&animate,
+		"vis",
+		"vis-scatter",
+		"Visualization to animate: vis-scatter, vis-polar-scatter, etc.")
 
 	flag.Parse()
 
@@ -202,6 +556,22 @@ func main() {
 	iPath = flag.Arg(1)
 	oPath = flag.Arg(2)
 
+	if minRunTime <= 0 {
+		log.Fatalln("Minimum run time must be positive.")
+	}
+
+	// Size text output to fit the terminal, unless a width was specified.
+	if format == "text" && !flagSet("width") {
+		w = terminalWidth()
+	}
+
+	// Unless a baseline time range was specified for comparisons, compare
+	// against the range of equal length immediately preceding the one being
+	// visualized.
+	if cA < 0 && cΩ < 0 {
+		cA, cΩ = 2*tA-tΩ, tA
+	}
+
 	if handler, exists := handlers[action]; exists {
 		handler()
 	} else {
@@ -209,26 +579,482 @@ func main() {
 	}
 }
 
-func visualize(v perspective.Visualizer) {
+func errorClassNames() []string {
+	names, err := feeds.ReadErrorClassNames(errorClassConf)
+	if err != nil {
+		log.Println("Failed to read error class names.")
+		log.Fatalln(err)
+	}
+	return names
+}
+
+func annotations() []perspective.Annotation {
+
+	in, err := os.Open(notesPath)
+	if err != nil {
+		log.Println("Failed to open annotations file for reading.")
+		log.Fatalln(err)
+	}
+
+	defer in.Close()
 
+	notes, err := perspective.ParseAnnotations(in)
+	if err != nil {
+		log.Println("Failed to parse annotations.")
+		log.Fatalln(err)
+	}
+	return notes
+}
+
+// Open the output file for writing, or use standard output if the output path
+// is "-".
+func createOutput() *os.File {
+	if oPath == "-" {
+		return os.Stdout
+	}
 	out, err := os.Create(oPath)
 	if err != nil {
 		log.Println("Failed to open output file for writing.")
 		log.Fatalln(err)
 	}
+	return out
+}
+
+// Whether the flag of the specified name was set on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func jitter() *perspective.Jitter {
+	switch jitterMode {
+	case "random":
+		return perspective.NewJitter(seed, false)
+	case "event-id":
+		return perspective.NewJitter(seed, true)
+	}
+	log.Fatalln("Unrecognized jitter mode.")
+	return nil
+}
 
-	eventData := feeds.MapBinLogFile(iPath, int64(lookback))
+// Get the longest run time expected of an event, in seconds.
+func runTimeLimit() int {
+	limit, err := perspective.ParseDuration(maxRunTime)
+	if err != nil {
+		log.Println("Failed to parse maximum run time.")
+		log.Fatalln(err)
+	}
+	if limit < 0 {
+		log.Fatalln("Maximum run time must not be negative.")
+	}
+	return limit
+}
+
+// Get the limit on how far back from the end of a feed to read, as a count of
+// events or a span of time.
+func lookback() feeds.Lookback {
+	l, err := feeds.ParseLookback(lookbackSpec)
+	if err != nil {
+		log.Println("Failed to parse lookback.")
+		log.Fatalln(err)
+	}
+	return l
+}
+
+func calendar() perspective.Visualizer {
+	if colorBy != "rate" && colorBy != "failures" {
+		log.Fatalln("Unrecognized calendar shading.")
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		log.Println("Failed to load time zone.")
+		log.Fatalln(err)
+	}
+	return perspective.NewCalendar(
+		w, h, theme(), tA, tΩ, loc, colorBy == "failures", labels)
+}
+
+// Get the SLO defined by the command-line options, scoped to the event type and
+// region filters.
+func slo() perspective.SLO {
+	window, err := perspective.ParseDuration(sloWindow)
+	if err != nil {
+		log.Println("Failed to parse SLO window.")
+		log.Fatalln(err)
+	}
+	s := perspective.SLO{
+		Target: sloTarget,
+		Window: window,
+		Type:   typeFilter,
+		Region: regionFilter}
+	if err := s.Validate(); err != nil {
+		log.Println("Invalid SLO.")
+		log.Fatalln(err)
+	}
+	return s
+}
+
+func successRateBreakdown() perspective.SuccessRateBreakdown {
+	byType, byRegion := false, false
+	switch groupBy {
+	case "type":
+		byType = true
+	case "region":
+		byRegion = true
+	case "both":
+		byType, byRegion = true, true
+	default:
+		log.Fatalln("Unrecognized success-rate grouping.")
+	}
+	byVolume := false
+	switch sortBy {
+	case "rate":
+	case "volume":
+		byVolume = true
+	default:
+		log.Fatalln("Unrecognized success-rate order.")
+	}
+	v := perspective.NewSuccessRateBreakdown(
+		w, h, theme(), byType, byRegion, byVolume, labels)
+	return v.(perspective.SuccessRateBreakdown)
+}
+
+func theme() *perspective.Theme {
+	t, exists := perspective.Themes[themeName]
+	if !exists {
+		log.Fatalln("Unrecognized theme.")
+	}
+	if bg >= 0 {
+		t = t.WithBackground(bg)
+	}
+	return t
+}
+
+func visualize(v perspective.Visualizer) {
+
+	// Refuse a request for data from a visualization which has none to export
+	// before any output is created, rather than leaving an empty file behind.
+	if _, ok := v.(perspective.DataVisualizer); !ok && format == "json" {
+		log.Fatalln("Visualization does not support data export.")
+	}
+
+	if a, ok := v.(perspective.Annotatable); ok && notesPath != "" {
+		a.Annotate(annotations())
+	}
+
+	if n, ok := v.(perspective.ErrorClassNamer); ok && errorClassConf != "" {
+		n.NameErrorClasses(errorClassNames())
+	}
+
+	out := createOutput()
+
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(tA), int64(tΩ), lookback())
+	if eventData == nil {
+		log.Fatalln("Failed to parse data feed.")
+	}
+
+	switch format {
+	case "png":
+		feeds.GeneratePNGFromBinLog(
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			v,
+			workers,
+			out)
+	case "svg":
+		err := feeds.GenerateSVGFromBinLog(
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			v,
+			workers,
+			out)
+		if err != nil {
+			log.Println("Failed to render SVG output.")
+			log.Fatalln(err)
+		}
+	case "text":
+		err := feeds.GenerateTextFromBinLog(
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			v,
+			workers,
+			out)
+		if err != nil {
+			log.Println("Failed to render text output.")
+			log.Fatalln(err)
+		}
+	case "json":
+		err := feeds.GenerateJSONFromBinLog(
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			v,
+			workers,
+			out)
+		if err != nil {
+			log.Println("Failed to export visualization data.")
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalln("Unrecognized output format.")
+	}
+}
+
+func estimatePercentiles(v perspective.PercentileEstimator) {
+
+	ps, err := perspective.ParsePercentiles(percentiles)
+	if err != nil {
+		log.Println("Failed to parse percentiles.")
+		log.Fatalln(err)
+	}
+
+	out := createOutput()
+
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(tA), int64(tΩ), lookback())
 	if eventData == nil {
 		log.Fatalln("Failed to parse data feed.")
 	}
 
-	feeds.GeneratePNGFromBinLog(
+	err = feeds.GetRunTimePercentiles(
 		eventData,
-		int32(tA),
-		int32(tΩ),
+		start,
+		int64(tΩ),
 		typeFilter,
 		regionFilter,
 		statusFilter,
 		v,
+		ps,
+		workers,
 		out)
+	if err != nil {
+		log.Println("Failed to write percentiles.")
+		log.Fatalln(err)
+	}
+}
+
+func reportSLO(s perspective.SLO) {
+
+	windows, err := perspective.ParseDurations(burnWindows)
+	if err != nil {
+		log.Println("Failed to parse burn-rate windows.")
+		log.Fatalln(err)
+	}
+
+	out := createOutput()
+
+	// Only events within the longest of the SLO and burn-rate windows before
+	// the time of the report are counted toward it.
+	span := s.Window
+	for _, window := range windows {
+		if window > span {
+			span = window
+		}
+	}
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(tΩ-span), int64(tΩ), lookback())
+	if eventData == nil {
+		log.Fatalln("Failed to parse data feed.")
+	}
+
+	err = feeds.GetSLOReport(eventData, start, int64(tΩ), s, windows, out)
+	if err != nil {
+		log.Println("Failed to write SLO report.")
+		log.Fatalln(err)
+	}
+}
+
+func breakDownSuccessRates(v perspective.SuccessRateBreakdown) {
+
+	out := createOutput()
+
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(tA), int64(tΩ), lookback())
+	if eventData == nil {
+		log.Fatalln("Failed to parse data feed.")
+	}
+
+	err := feeds.GetSuccessRateBreakdown(
+		eventData,
+		start,
+		int64(tΩ),
+		typeFilter,
+		regionFilter,
+		v,
+		workers,
+		out)
+	if err != nil {
+		log.Println("Failed to write success rates.")
+		log.Fatalln(err)
+	}
+}
+
+func compare(c perspective.Comparator) {
+
+	out := createOutput()
+
+	// The baseline is taken from the same mapping if it comes from the same
+	// feed, so the mapping must cover both time ranges.
+	mA, mΩ := tA, tΩ
+	if compareFeed == "" && cA < mA {
+		mA = cA
+	}
+	if compareFeed == "" && cΩ > mΩ {
+		mΩ = cΩ
+	}
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(mA), int64(mΩ), lookback())
+	if eventData == nil {
+		log.Fatalln("Failed to parse data feed.")
+	}
+
+	// Either time range is cut short by the start of its feed's lookback.
+	baseData, baseStart := eventData, start
+	if compareFeed != "" {
+		baseData, baseStart = feeds.MapBinLogRange(
+			compareFeed, int64(cA), int64(cΩ), lookback())
+		if baseData == nil {
+			log.Fatalln("Failed to parse baseline data feed.")
+		}
+	}
+	if start < int64(tA) {
+		start = int64(tA)
+	}
+	if baseStart < int64(cA) {
+		baseStart = int64(cA)
+	}
+
+	switch format {
+	case "png":
+		feeds.GenerateComparisonPNGFromBinLogs(
+			baseData,
+			baseStart,
+			int64(cΩ),
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			c,
+			workers,
+			out)
+	case "svg":
+		err := feeds.GenerateComparisonSVGFromBinLogs(
+			baseData,
+			baseStart,
+			int64(cΩ),
+			eventData,
+			start,
+			int64(tΩ),
+			typeFilter,
+			regionFilter,
+			statusFilter,
+			c,
+			workers,
+			out)
+		if err != nil {
+			log.Println("Failed to render SVG output.")
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalln("Unrecognized output format.")
+	}
+}
+
+func timelapse(factory feeds.VisualizerFactory) {
+
+	if errorClassConf != "" {
+		names := errorClassNames()
+		unnamed := factory
+		factory = func(tA int, tΩ int) perspective.Visualizer {
+			v := unnamed(tA, tΩ)
+			if n, ok := v.(perspective.ErrorClassNamer); ok {
+				n.NameErrorClasses(names)
+			}
+			return v
+		}
+	}
+
+	out := createOutput()
+
+	// A lookback with a span of time moves the start of the timelapse forward.
+	eventData, start := feeds.MapBinLogRange(
+		iPath, int64(tA), int64(tΩ), lookback())
+	if eventData == nil {
+		log.Fatalln("Failed to parse data feed.")
+	}
+
+	// Timelapses are encoded as animated PNGs unless a GIF is requested.
+	generate := feeds.GenerateAPNGTimelapseFromBinLog
+	switch format {
+	case "png", "apng":
+	case "gif":
+		generate = feeds.GenerateGIFTimelapseFromBinLog
+	default:
+		log.Fatalln("Unrecognized output format.")
+	}
+
+	err := generate(
+		eventData,
+		start,
+		int64(tΩ),
+		int64(window),
+		int64(stride),
+		typeFilter,
+		regionFilter,
+		statusFilter,
+		factory,
+		frameDelay,
+		workers,
+		out)
+	if err != nil {
+		log.Println("Failed to render timelapse.")
+		log.Fatalln(err)
+	}
+}
+
+// Get the width of the terminal in characters, from the COLUMNS environment
+// variable if it is set or from the terminal attached to standard output if
+// not, falling back to a traditional 80 columns.
+func terminalWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+		return columns
+	}
+	var size struct {
+		rows    uint16
+		columns uint16
+		xPixels uint16
+		yPixels uint16
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		os.Stdout.Fd(),
+		syscall.TIOCGWINSZ,
+		uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.columns == 0 {
+		return 80
+	}
+	return int(size.columns)
 }
@@ -18,6 +18,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/cparo/perspective"
 	"github.com/cparo/perspective/feeds"
@@ -26,6 +27,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -38,6 +40,11 @@ const staticContentPath = "/var/opt/perspective/static/"
 // Mapping of action names to handler functions:
 var handlers = make(map[string]func(http.ResponseWriter, *options))
 
+// Mapping of visualization names to constructors for the visualization
+// generators which can be animated as timelapses:
+var timelapses = make(
+	map[string]func(r *options, tA int, tΩ int) perspective.Visualizer)
+
 // Options and arguments:
 type options struct {
 	statusFilter int     // Least significant bits: {done, failed, running}.
@@ -49,41 +56,171 @@ type options struct {
 	pτ           int     // The interval length for periodic visualizations.
 	xGrid        int     // Number of horizontal grid divisions.
 	yLog2        float64 // Number of pixels over which elapsed times double.
+	minRunTime   float64 // Run time at the origin of run-time axes.
+	maxRunTime   string  // Longest run time expected of an event, like "1d".
 	w            int     // Visualization width, in pixels.
 	h            int     // Visualization height, in pixels.
-	bg           int     // Graph background color.
+	bg           int     // Graph background gray level, if non-negative.
 	colors       float64 // The number of color steps before saturation.
 	resonance    float64 // Resonance value for line-smoothing.
 	feed         string  // Input feed name.
-	lookback     int     // Events to look back through in feed (0 for all).
+	lookback     string  // Events or time span to look back through in feed.
+	format       string  // Output format for visualizations or dumps.
+	labels       bool    // Whether to draw axis labels and legends.
+	workers      int     // Number of goroutines to record events with.
+	seed         int     // Seed for random jitter applied to run times.
+	jitterMode   string  // Source of jitter (random or event-id).
+	themeName    string  // Name of the color theme for visualizations.
+	stuckP       int     // Progress percentage below which events may stick.
+	stuckT       int     // Run time in seconds after which events may stick.
+	compareFeed  string  // Baseline input feed name to compare to.
+	cA           int     // Lower limit of baseline time range to compare to.
+	cΩ           int     // Upper limit of baseline time range to compare to.
+	compareFails bool    // Whether to compare only failed events.
+	window       int     // Width of the sliding window of a timelapse.
+	stride       int     // Step between the frames of a timelapse.
+	frameDelay   int     // Time to show each frame of a timelapse, in ms.
+	animate      string  // Name of the visualization to animate.
+	byClass      bool    // Whether to split failures by error class.
+	percentiles  string  // Run-time percentiles to estimate, comma-separated.
+	groupBy      string  // Fields to group success rates by.
+	sortBy       string  // Order of success-rate groups (rate or volume).
+	timeZone     string  // Time zone in which calendar days are reckoned.
+	colorBy      string  // Shading of calendar days (rate or failures).
+	violin       bool    // Whether to draw box plots as violins.
+	sloTarget    float64 // Target success ratio of an SLO.
+	sloWindow    string  // Trailing window of an SLO, like "30d".
+	burnWindows  string  // Windows to report burn rates over, like "1h,1d".
+
+	// Annotations to draw over the time axes of visualizations.
+	notes []perspective.Annotation
 }
 
 func init() {
 
+	handlers["vis-box-plot"] = func(out http.ResponseWriter, r *options) {
+		byRegion := r.groupBy == "region"
+		if r.groupBy != "type" && !byRegion {
+			logMalformedOption("group-by", r.groupBy)
+		}
+		visualize(
+			perspective.NewBoxPlot(
+				r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), byRegion,
+				r.violin, r.labels, r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["vis-calendar"] = func(out http.ResponseWriter, r *options) {
+		visualize(r.calendar(), out, r)
+	}
+
+	handlers["vis-concurrency"] = func(out http.ResponseWriter, r *options) {
+		v := perspective.NewConcurrency(
+			r.w, r.h, r.theme(), r.tA, r.tΩ, r.xGrid, r.labels)
+
+		// Events which started before the time range may still be running in
+		// it, so those which started up to the longest run time expected of an
+		// event before it must be recorded as well.
+		r.tA -= r.runTimeLimit()
+		visualize(v, out, r)
+	}
+
 	handlers["vis-count-lines"] = func(out http.ResponseWriter, r *options) {
 		visualize(
 			perspective.NewCountLines(
-				r.w, r.h, r.bg, r.tA, r.tΩ, r.resonance, r.xGrid),
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.resonance, r.xGrid,
+				r.labels),
 			out,
 			r)
 	}
 
+	handlers["survival-percentiles"] = func(
+		out http.ResponseWriter,
+		r *options) {
+
+		v := perspective.NewSurvival(
+			r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.labels,
+			r.jitter())
+		estimatePercentiles(v.(perspective.PercentileEstimator), out, r)
+	}
+
 	handlers["vis-run-time-line"] = func(out http.ResponseWriter, r *options) {
 		visualize(
 			perspective.NewRunTimeLine(
-				r.w, r.h, r.bg, r.tA, r.tΩ, r.yLog2, r.xGrid),
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.yLog2, r.runTimeOrigin(),
+				r.xGrid, r.labels),
+			out,
+			r)
+	}
+
+	handlers["vis-error-budget"] = func(out http.ResponseWriter, r *options) {
+		s := r.slo()
+		v := perspective.NewErrorBudget(
+			r.w, r.h, r.theme(), r.tA, r.tΩ, s, r.xGrid, r.labels)
+
+		// Events over the SLO window before the time range count toward the
+		// budget at its start, so they must be recorded as well.
+		r.tA -= s.Window
+		visualize(v, out, r)
+	}
+
+	handlers["vis-error-stack"] = func(out http.ResponseWriter, r *options) {
+		visualize(
+			perspective.NewErrorStack(
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.resonance, r.xGrid,
+				r.labels),
 			out,
 			r)
 	}
 
 	handlers["vis-histogram"] = func(out http.ResponseWriter, r *options) {
-		visualize(perspective.NewHistogram(r.w, r.h, r.bg, r.yLog2), out, r)
+		visualize(
+			perspective.NewHistogram(
+				r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.labels,
+				r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["vis-histogram-compare"] = func(
+		out http.ResponseWriter,
+		r *options) {
+
+		compare(
+			perspective.NewHistogramComparison(
+				r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.compareFails,
+				r.labels, r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["vis-percentile-bands"] = func(
+		out http.ResponseWriter,
+		r *options) {
+
+		visualize(
+			perspective.NewPercentileBands(
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.yLog2, r.runTimeOrigin(),
+				r.xGrid, r.labels),
+			out,
+			r)
 	}
 
 	handlers["vis-polar-scatter"] = func(out http.ResponseWriter, r *options) {
 		visualize(
 			perspective.NewPolarScatter(
-				r.w, r.h, r.bg, r.tA, r.tΩ, r.p0, r.pτ, r.yLog2, r.colors),
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.p0, r.pτ, r.yLog2,
+				r.runTimeOrigin(), r.colors, r.byClass, r.labels, r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["vis-progress"] = func(out http.ResponseWriter, r *options) {
+		visualize(
+			perspective.NewProgress(
+				r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.colors,
+				r.stuckP, r.stuckT, r.labels, r.jitter()),
 			out,
 			r)
 	}
@@ -91,25 +228,206 @@ func init() {
 	handlers["vis-scatter"] = func(out http.ResponseWriter, r *options) {
 		visualize(
 			perspective.NewScatter(
-				r.w, r.h, r.bg, r.tA, r.tΩ, r.yLog2, r.colors, r.xGrid),
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.yLog2, r.runTimeOrigin(),
+				r.colors, r.xGrid, r.byClass, r.labels, r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["vis-scatter-compare"] = func(
+		out http.ResponseWriter,
+		r *options) {
+
+		compare(
+			perspective.NewScatterComparison(
+				r.w, r.h, r.theme(), r.tA, r.tΩ, r.cA, r.cΩ, r.yLog2,
+				r.runTimeOrigin(), r.colors, r.xGrid, r.compareFails, r.labels,
+				r.jitter()),
 			out,
 			r)
 	}
+
+	handlers["success-rates"] = func(out http.ResponseWriter, r *options) {
+		breakDownSuccessRates(r.successRateBreakdown(), out, r)
+	}
+
+	handlers["vis-success-rates"] = func(out http.ResponseWriter, r *options) {
+		visualize(r.successRateBreakdown(), out, r)
+	}
+
+	handlers["vis-survival"] = func(out http.ResponseWriter, r *options) {
+		visualize(
+			perspective.NewSurvival(
+				r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.labels,
+				r.jitter()),
+			out,
+			r)
+	}
+
+	handlers["timelapse"] = timelapse
+
+	timelapses["vis-histogram"] = func(
+		r *options,
+		tA int,
+		tΩ int) perspective.Visualizer {
+
+		return perspective.NewHistogram(
+			r.w, r.h, r.theme(), r.yLog2, r.runTimeOrigin(), r.labels,
+			r.jitter())
+	}
+
+	timelapses["vis-polar-scatter"] = func(
+		r *options,
+		tA int,
+		tΩ int) perspective.Visualizer {
+
+		return perspective.NewPolarScatter(
+			r.w, r.h, r.theme(), tA, tΩ, r.p0, r.pτ, r.yLog2, r.runTimeOrigin(),
+			r.colors, r.byClass, r.labels, r.jitter())
+	}
+
+	timelapses["vis-scatter"] = func(
+		r *options,
+		tA int,
+		tΩ int) perspective.Visualizer {
+
+		return perspective.NewScatter(
+			r.w, r.h, r.theme(), tA, tΩ, r.yLog2, r.runTimeOrigin(), r.colors,
+			r.xGrid, r.byClass, r.labels, r.jitter())
+	}
+}
+
+func boolOpt(values url.Values, name string, defaultValue bool) bool {
+	strValue := values.Get(name)
+	if strValue == "" {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(strValue)
+	if err != nil {
+		logMalformedOption(name, strValue)
+		return defaultValue
+	}
+	return boolValue
+}
+
+func breakDownSuccessRates(
+	v perspective.SuccessRateBreakdown,
+	out http.ResponseWriter,
+	r *options) {
+
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
+	if eventData == nil {
+		return
+	}
+	out.Header().Set("Content-Type", "application/json")
+	err := feeds.GetSuccessRateBreakdown(
+		eventData,
+		start,
+		int64(r.tΩ),
+		r.typeFilter,
+		r.regionFilter,
+		v,
+		r.workers,
+		out)
+	if err != nil {
+		log.Println(err)
+	}
+	feeds.UnmapBinLogFile(eventData)
 }
 
+func compare(c perspective.Comparator, out http.ResponseWriter, r *options) {
+
+	if r.format != "png" && r.format != "svg" {
+		msg := fmt.Sprintf("Unrecognized output format: \"%s\"", r.format)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	// The baseline is taken from the same mapping if it comes from the same
+	// feed, so the mapping must cover both time ranges.
+	mA, mΩ := r.tA, r.tΩ
+	if r.compareFeed == r.feed && r.cA < mA {
+		mA = r.cA
+	}
+	if r.compareFeed == r.feed && r.cΩ > mΩ {
+		mΩ = r.cΩ
+	}
+	eventData, start := loadFeed(r.feed, mA, mΩ, r.lookbackLimit(), out)
+	if eventData == nil {
+		return
+	}
+	defer feeds.UnmapBinLogFile(eventData)
+
+	// Either time range is cut short by the start of its feed's lookback.
+	baseData, baseStart := eventData, start
+	if r.compareFeed != r.feed {
+		baseData, baseStart = loadFeed(
+			r.compareFeed, r.cA, r.cΩ, r.lookbackLimit(), out)
+		if baseData == nil {
+			return
+		}
+		defer feeds.UnmapBinLogFile(baseData)
+	}
+	if start < int64(r.tA) {
+		start = int64(r.tA)
+	}
+	if baseStart < int64(r.cA) {
+		baseStart = int64(r.cA)
+	}
+
+	if r.format == "svg" {
+		out.Header().Set("Content-Type", "image/svg+xml")
+		err := feeds.GenerateComparisonSVGFromBinLogs(
+			baseData,
+			baseStart,
+			int64(r.cΩ),
+			eventData,
+			start,
+			int64(r.tΩ),
+			r.typeFilter,
+			r.regionFilter,
+			r.statusFilter,
+			c,
+			r.workers,
+			out)
+		if err != nil {
+			log.Println(err)
+		}
+	} else {
+		out.Header().Set("Content-Type", "image/png")
+		feeds.GenerateComparisonPNGFromBinLogs(
+			baseData,
+			baseStart,
+			int64(r.cΩ),
+			eventData,
+			start,
+			int64(r.tΩ),
+			r.typeFilter,
+			r.regionFilter,
+			r.statusFilter,
+			c,
+			r.workers,
+			out)
+	}
+}
+
+// Write out the event data as packed int32 fields, with run times in whole
+// seconds, or as packed float64 fields if the "float64" format is requested.
 func dumpEventData(out http.ResponseWriter, r *options) {
 
-	eventData := loadFeed(r.feed, r.lookback, out)
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
 	if eventData == nil {
 		return
 	}
 	feeds.DumpEventData(
 		eventData,
-		int32(r.tA),
-		int32(r.tΩ),
+		start,
+		int64(r.tΩ),
 		r.typeFilter,
 		r.regionFilter,
 		r.statusFilter,
+		r.format == "float64",
 		out)
 	feeds.UnmapBinLogFile(eventData)
 }
@@ -129,20 +447,52 @@ func f64Opt(values url.Values, name string, defaultValue float64) float64 {
 
 func getSuccessRate(out http.ResponseWriter, r *options) {
 
-	eventData := loadFeed(r.feed, r.lookback, out)
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
 	if eventData == nil {
 		return
 	}
 	feeds.GetSuccessRate(
 		eventData,
-		int32(r.tA),
-		int32(r.tΩ),
+		start,
+		int64(r.tΩ),
 		r.typeFilter,
 		r.regionFilter,
 		out)
 	feeds.UnmapBinLogFile(eventData)
 }
 
+func getSLOStatus(out http.ResponseWriter, r *options) {
+
+	windows, err := perspective.ParseDurations(r.burnWindows)
+	if err != nil {
+		msg := fmt.Sprintf("Malformed burn-rate windows: %s", err)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	// Only events within the longest of the SLO and burn-rate windows before
+	// the time of the report are counted toward it.
+	s := r.slo()
+	span := s.Window
+	for _, window := range windows {
+		if window > span {
+			span = window
+		}
+	}
+	eventData, start := loadFeed(
+		r.feed, r.tΩ-span, r.tΩ, r.lookbackLimit(), out)
+	if eventData == nil {
+		return
+	}
+	out.Header().Set("Content-Type", "application/json")
+	err = feeds.GetSLOReport(eventData, start, int64(r.tΩ), s, windows, out)
+	if err != nil {
+		log.Println(err)
+	}
+	feeds.UnmapBinLogFile(eventData)
+}
+
 func hasUnitSuffix(value string, unit string) (trimmed string, match bool) {
 	if strings.HasSuffix(value, unit) {
 		return strings.TrimSuffix(value, unit), true
@@ -163,6 +513,127 @@ func intOpt(values url.Values, name string, defaultValue int) int {
 	return intValue
 }
 
+// Get the source of jitter for visualizations which apply random noise to event
+// run times, falling back to seeded random jitter if the mode is malformed.
+func (r *options) jitter() *perspective.Jitter {
+	if r.jitterMode == "event-id" {
+		return perspective.NewJitter(int64(r.seed), true)
+	}
+	if r.jitterMode != "random" {
+		logMalformedOption("jitter", r.jitterMode)
+	}
+	return perspective.NewJitter(int64(r.seed), false)
+}
+
+// Get the run time at the origin of run-time axes, falling back to one second
+// if it is not positive.
+func (r *options) runTimeOrigin() float64 {
+	if r.minRunTime <= 0 {
+		logMalformedOption("min-run-time", fmt.Sprint(r.minRunTime))
+		return 1
+	}
+	return r.minRunTime
+}
+
+// Get the longest run time expected of an event, in seconds, falling back to
+// one day if it is malformed.
+func (r *options) runTimeLimit() int {
+	limit, err := perspective.ParseDuration(r.maxRunTime)
+	if err != nil || limit < 0 {
+		logMalformedOption("max-run-time", r.maxRunTime)
+		return 86400
+	}
+	return limit
+}
+
+// Get a calendar-heatmap-visualization generator for the requested time zone
+// and shading, falling back to the server's local time zone and to shading by
+// rate if either option is malformed.
+func (r *options) calendar() perspective.Visualizer {
+	if r.colorBy != "rate" && r.colorBy != "failures" {
+		logMalformedOption("color-by", r.colorBy)
+	}
+	loc, err := time.LoadLocation(r.timeZone)
+	if err != nil {
+		logMalformedOption("time-zone", r.timeZone)
+		loc = time.Local
+	}
+	return perspective.NewCalendar(
+		r.w, r.h, r.theme(), r.tA, r.tΩ, loc, r.colorBy == "failures",
+		r.labels)
+}
+
+// Get the limit on how far back from the end of a feed to read, as a count of
+// events or a span of time, falling back to no limit if it is malformed.
+func (r *options) lookbackLimit() feeds.Lookback {
+	l, err := feeds.ParseLookback(r.lookback)
+	if err != nil {
+		logMalformedOption("lookback", r.lookback)
+		return feeds.Lookback{}
+	}
+
+	// All lookback values should be positive.
+	if l.Events < 0 {
+		l.Events = -l.Events
+	}
+	if l.Span < 0 {
+		l.Span = -l.Span
+	}
+	return l
+}
+
+// Get the SLO defined by the request options, scoped to the event type and
+// region filters, falling back to a 99.9% target over a 30-day window if
+// either is malformed.
+func (r *options) slo() perspective.SLO {
+	window, err := perspective.ParseDuration(r.sloWindow)
+	if err != nil || window <= 0 {
+		logMalformedOption("slo-window", r.sloWindow)
+		window = 30 * 86400
+	}
+	s := perspective.SLO{
+		Target: r.sloTarget,
+		Window: window,
+		Type:   r.typeFilter,
+		Region: r.regionFilter}
+	if s.Validate() != nil {
+		logMalformedOption("slo-target", fmt.Sprint(r.sloTarget))
+		s.Target = 0.999
+	}
+	return s
+}
+
+// Get a success-rate-breakdown-visualization generator for the requested
+// grouping and order, falling back to grouping by type and sorting by rate if
+// either option is malformed.
+func (r *options) successRateBreakdown() perspective.SuccessRateBreakdown {
+	byType, byRegion := r.groupBy != "region", r.groupBy != "type"
+	if r.groupBy != "type" && r.groupBy != "region" && r.groupBy != "both" {
+		logMalformedOption("group-by", r.groupBy)
+		byType, byRegion = true, false
+	}
+	if r.sortBy != "rate" && r.sortBy != "volume" {
+		logMalformedOption("sort-by", r.sortBy)
+	}
+	v := perspective.NewSuccessRateBreakdown(
+		r.w, r.h, r.theme(), byType, byRegion, r.sortBy == "volume", r.labels)
+	return v.(perspective.SuccessRateBreakdown)
+}
+
+// Get the color theme for visualizations, falling back to the default theme if
+// the theme name is malformed, and applying any override of the background.
+func (r *options) theme() *perspective.Theme {
+	t, exists := perspective.Themes[r.themeName]
+	if !exists {
+		logMalformedOption("theme", r.themeName)
+		t = perspective.DarkTheme
+	}
+	if r.bg >= 0 {
+		t = t.WithBackground(r.bg)
+	}
+	return t
+}
+
 func logMalformedOption(name string, value string) {
 	log.Printf(
 		"Malformed option: %s = \"%s\", falling back to default.\n",
@@ -247,6 +718,18 @@ func receiveEventData(request *http.Request, response http.ResponseWriter) {
 			500)
 		return
 	}
+
+	// Index uploaded feeds so that queries over a time range can skip the
+	// parts of them which are irrelevant. An index left over from a feed this
+	// one replaced would be wrong for it, so it is removed if this one could
+	// not be indexed, leaving the feed to be read without an index.
+	if strings.HasSuffix(header.Filename, ".dat") {
+		path := dataPath + header.Filename
+		if err := feeds.BuildBinLogIndex(path); err != nil {
+			log.Printf("Failed to index feed file: %s\n", err)
+			os.Remove(feeds.BinLogIndexPath(path))
+		}
+	}
 }
 
 func responder(response http.ResponseWriter, request *http.Request) {
@@ -255,27 +738,88 @@ func responder(response http.ResponseWriter, request *http.Request) {
 	// where options are missing or malformed:
 	now := int(time.Now().Unix())
 	values := request.URL.Query()
+	tA := timeOpt(values, "min-time", 0)
+	tΩ := timeOpt(values, "max-time", now)
+	feed := strOpt(values, "feed", "")
+
+	// The default theme is drawn over the server's traditional background of
+	// gray 33, while other themes keep their own backgrounds unless another
+	// is requested.
+	themeName := strOpt(values, "theme", "dark")
+	bg := -1
+	if themeName == "dark" {
+		bg = 33
+	}
+
+	// Annotations for time-axis visualizations may be posted as a JSON array
+	// in the body of a request. The post-data action has its own use for the
+	// request body, so it is left alone.
+	var notes []perspective.Annotation
+	if request.Method == "POST" && request.URL.Path != "/post-data" {
+		var err error
+		notes, err = perspective.ParseAnnotations(request.Body)
+		if err != nil {
+			msg := fmt.Sprintf("Malformed annotations: %s", err)
+			log.Println(msg)
+			http.Error(response, msg, 400)
+			return
+		}
+	}
+
 	options := &options{
 		intOpt(values, "status-filter", -1),
 		intOpt(values, "event-type", -1),
 		intOpt(values, "region", -1),
-		timeOpt(values, "min-time", 0),
-		timeOpt(values, "max-time", now),
+		tA,
+		tΩ,
 		timeOpt(values, "period-start", now),
 		timeOpt(values, "period-length", -1),
 		intOpt(values, "x-grid", 0),
 		f64Opt(values, "run-time-scale", 16),
+		f64Opt(values, "min-run-time", 1),
+		strOpt(values, "max-run-time", "1d"),
 		intOpt(values, "width", 256),
 		intOpt(values, "height", 256),
-		intOpt(values, "bg", 33),
+		intOpt(values, "bg", bg),
 		f64Opt(values, "color-steps", 1),
 		f64Opt(values, "smoothing-resonance", 0.85),
-		strOpt(values, "feed", ""),
-		intOpt(values, "lookback", 0)}
+		feed,
+		strOpt(values, "lookback", "0"),
+		strOpt(values, "format", "png"),
+		boolOpt(values, "labels", true),
+		intOpt(values, "workers", runtime.NumCPU()),
+		intOpt(values, "seed", 0),
+		strOpt(values, "jitter", "random"),
+		themeName,
+		intOpt(values, "stuck-progress", 10),
+		intOpt(values, "stuck-run-time", 3600),
+		strOpt(values, "compare-feed", feed),
+		timeOpt(values, "compare-min-time", 2*tA-tΩ),
+		timeOpt(values, "compare-max-time", tA),
+		boolOpt(values, "compare-failures", false),
+		intOpt(values, "window", 3600),
+		intOpt(values, "stride", 600),
+		intOpt(values, "frame-delay", 100),
+		strOpt(values, "vis", "vis-scatter"),
+		boolOpt(values, "by-error-class", false),
+		strOpt(values, "percentiles", "50,90,99"),
+		strOpt(values, "group-by", "type"),
+		strOpt(values, "sort-by", "rate"),
+		strOpt(values, "time-zone", "Local"),
+		strOpt(values, "color-by", "rate"),
+		boolOpt(values, "violin", false),
+		f64Opt(values, "slo-target", 0.999),
+		strOpt(values, "slo-window", "30d"),
+		strOpt(values, "burn-windows", "1h,6h,1d,3d"),
+		notes}
 
-	// All lookback values should be positive.
-	if options.lookback < 0 {
-		options.lookback = -options.lookback
+	// Each worker records events into its own copy of the visualization, so
+	// requests are held to between one worker and one for each CPU.
+	if options.workers < 1 {
+		options.workers = 1
+	}
+	if options.workers > runtime.NumCPU() {
+		options.workers = runtime.NumCPU()
 	}
 
 	action := request.URL.Path[1:]
@@ -294,6 +838,12 @@ func responder(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// Special case to handle a request for a report on an SLO's error budget.
+	if action == "slo-status" {
+		getSLOStatus(response, options)
+		return
+	}
+
 	// Special case to handle a request for to push feed data.
 	if action == "post-data" {
 		receiveEventData(request, response)
@@ -320,6 +870,106 @@ func strOpt(values url.Values, name string, defaultValue string) string {
 	return strValue
 }
 
+func estimatePercentiles(
+	v perspective.PercentileEstimator,
+	out http.ResponseWriter,
+	r *options) {
+
+	ps, err := perspective.ParsePercentiles(r.percentiles)
+	if err != nil {
+		msg := fmt.Sprintf("Malformed percentiles: %s", err)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
+	if eventData == nil {
+		return
+	}
+	out.Header().Set("Content-Type", "text/plain")
+	err = feeds.GetRunTimePercentiles(
+		eventData,
+		start,
+		int64(r.tΩ),
+		r.typeFilter,
+		r.regionFilter,
+		r.statusFilter,
+		v,
+		ps,
+		r.workers,
+		out)
+	if err != nil {
+		log.Println(err)
+	}
+	feeds.UnmapBinLogFile(eventData)
+}
+
+func timelapse(out http.ResponseWriter, r *options) {
+
+	constructor, exists := timelapses[r.animate]
+	if !exists {
+		msg := fmt.Sprintf("Unrecognized visualization: \"%s\"", r.animate)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	// Timelapses are encoded as animated PNGs unless a GIF is requested.
+	generate := feeds.GenerateAPNGTimelapseFromBinLog
+	contentType := "image/apng"
+	switch r.format {
+	case "png", "apng":
+	case "gif":
+		generate = feeds.GenerateGIFTimelapseFromBinLog
+		contentType = "image/gif"
+	default:
+		msg := fmt.Sprintf("Unrecognized output format: \"%s\"", r.format)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	// A lookback with a span of time moves the start of the timelapse forward.
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
+	if eventData == nil {
+		return
+	}
+	defer feeds.UnmapBinLogFile(eventData)
+
+	names := errorClassNames(r.feed)
+
+	// Render the timelapse to a buffer first, so a bad window or stride can
+	// still be reported with an error status.
+	var buf bytes.Buffer
+	err := generate(
+		eventData,
+		start,
+		int64(r.tΩ),
+		int64(r.window),
+		int64(r.stride),
+		r.typeFilter,
+		r.regionFilter,
+		r.statusFilter,
+		func(tA int, tΩ int) perspective.Visualizer {
+			v := constructor(r, tA, tΩ)
+			if n, ok := v.(perspective.ErrorClassNamer); ok && names != nil {
+				n.NameErrorClasses(names)
+			}
+			return v
+		},
+		r.frameDelay,
+		r.workers,
+		&buf)
+	if err != nil {
+		log.Println(err)
+		http.Error(out, err.Error(), 400)
+		return
+	}
+	out.Header().Set("Content-Type", contentType)
+	buf.WriteTo(out)
+}
+
 func timeOpt(values url.Values, name string, defaultValue int) int {
 	strValue := values.Get(name)
 	// If no value is specified, fall back to default value...
@@ -387,26 +1037,98 @@ func timeOpt(values url.Values, name string, defaultValue int) int {
 
 func visualize(v perspective.Visualizer, out http.ResponseWriter, r *options) {
 
-	eventData := loadFeed(r.feed, r.lookback, out)
+	if r.format != "png" && r.format != "svg" && r.format != "json" {
+		msg := fmt.Sprintf("Unrecognized output format: \"%s\"", r.format)
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	if _, ok := v.(perspective.DataVisualizer); !ok && r.format == "json" {
+		msg := "Visualization does not support data export"
+		log.Println(msg)
+		http.Error(out, msg, 400)
+		return
+	}
+
+	if a, ok := v.(perspective.Annotatable); ok {
+		a.Annotate(r.notes)
+	}
+
+	if n, ok := v.(perspective.ErrorClassNamer); ok {
+		n.NameErrorClasses(errorClassNames(r.feed))
+	}
+
+	eventData, start := loadFeed(r.feed, r.tA, r.tΩ, r.lookbackLimit(), out)
 	if eventData == nil {
 		return
 	}
-	feeds.GeneratePNGFromBinLog(
-		eventData,
-		int32(r.tA),
-		int32(r.tΩ),
-		r.typeFilter,
-		r.regionFilter,
-		r.statusFilter,
-		v,
-		out)
+	switch r.format {
+	case "svg":
+		out.Header().Set("Content-Type", "image/svg+xml")
+		err := feeds.GenerateSVGFromBinLog(
+			eventData,
+			start,
+			int64(r.tΩ),
+			r.typeFilter,
+			r.regionFilter,
+			r.statusFilter,
+			v,
+			r.workers,
+			out)
+		if err != nil {
+			log.Println(err)
+		}
+	case "json":
+		out.Header().Set("Content-Type", "application/json")
+		err := feeds.GenerateJSONFromBinLog(
+			eventData,
+			start,
+			int64(r.tΩ),
+			r.typeFilter,
+			r.regionFilter,
+			r.statusFilter,
+			v,
+			r.workers,
+			out)
+		if err != nil {
+			log.Println(err)
+		}
+	default:
+		out.Header().Set("Content-Type", "image/png")
+		feeds.GeneratePNGFromBinLog(
+			eventData,
+			start,
+			int64(r.tΩ),
+			r.typeFilter,
+			r.regionFilter,
+			r.statusFilter,
+			v,
+			r.workers,
+			out)
+	}
 	feeds.UnmapBinLogFile(eventData)
 }
 
+// Get the names of the error classes of a feed, from the error-reason filter
+// config file kept alongside it under the same name, if there is one.
+func errorClassNames(feed string) []string {
+	names, err := feeds.ReadErrorClassNames(dataPath + feed + ".conf")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return nil
+	}
+	return names
+}
+
 func loadFeed(
 	feed string,
-	lookback int,
-	out http.ResponseWriter) *[]perspective.EventData {
+	tA int,
+	tΩ int,
+	lookback feeds.Lookback,
+	out http.ResponseWriter) (*[]perspective.EventData, int64) {
 
 	path := dataPath + feed + ".dat"
 
@@ -418,17 +1140,18 @@ func loadFeed(
 			out,
 			fmt.Sprintf("Specified Feed Not Found"),
 			404)
-		return nil
+		return nil, 0
 	}
 
-	eventData := feeds.MapBinLogFile(path, int64(lookback))
+	eventData, start := feeds.MapBinLogRange(
+		path, int64(tA), int64(tΩ), lookback)
 	if eventData == nil {
 		http.Error(
 			out,
 			fmt.Sprintf("Internal Server Error"),
 			500)
-		return nil
+		return nil, 0
 	}
 
-	return eventData
+	return eventData, start
 }
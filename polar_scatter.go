@@ -19,40 +19,51 @@ package perspective
 
 import (
 	"image"
+	"io"
 	"math"
-	"math/rand"
 )
 
 // Note that floating-point pre-rendering canvases have a two-pixel bleed on all
 // edges to allow for simple use of the bloom effect's convolution kernel.
 type polarScatter struct {
-	w     int       // Width of the visualization
-	h     int       // Height of the visualization
-	s     []float64 // Channel for successful events
-	f     []float64 // Channel for failed events
-	a     []float64 // Channel for active events
-	tA    float64   // Lower limit of time range to be visualized
-	tτ    float64   // Length of time range to be visualized
-	p0    float64   // Temporal period phase offset value
-	pτ    float64   // The periodic interval length
-	yLog2 float64   // Number of pixels over which elapsed times double
-	cΔ    float64   // Increment for color channel value increases
-	bg    int       // Background gray level
-	ϕΔ    float64   // Angular value, in radians, of a step in time
+	w       int         // Width of the visualization
+	h       int         // Height of the visualization
+	s       []float64   // Channel for successful events
+	f       []float64   // Channel for failed events
+	a       []float64   // Channel for active events
+	tA      float64     // Lower limit of time range to be visualized
+	tτ      float64     // Length of time range to be visualized
+	p0      float64     // Temporal period phase offset value
+	pτ      float64     // The periodic interval length
+	yLog2   float64     // Number of pixels over which elapsed times double
+	tMin    float64     // Run time at the center of the visualization
+	cΔ      float64     // Increment for color channel value increases
+	theme   *Theme      // Colors to render the visualization with
+	ϕΔ      float64     // Angular value, in radians, of a step in time
+	labels  bool        // Whether to draw axis labels and a legend
+	jitter  *Jitter     // Source of random noise applied to run times
+	byClass bool        // Whether to split failures by error class
+	classes [][]float64 // Channels for failed events by error class
+	names   []string    // Names of error classes for the legend
 }
 
 // NewPolarScatter returns a polar floating-point scatter-visualization
-// generator.
+// generator. If byErrorClass is set, failed events are plotted in a distinct
+// hue for each error class rather than all in the failure color.
 func NewPolarScatter(
 	width int,
 	height int,
-	bg int,
+	theme *Theme,
 	minTime int,
 	maxTime int,
 	phasePoint int,
 	period int,
 	yLog2 float64,
-	colorSteps float64) Visualizer {
+	minRunTime float64,
+	colorSteps float64,
+	byErrorClass bool,
+	labels bool,
+	jitter *Jitter) Visualizer {
 
 	// Ensure we have a positive, non-zero period length. If we don't (for
 	// instance, if none was specified by the end user and we were given a
@@ -79,9 +90,15 @@ func NewPolarScatter(
 		float64(phasePoint%period - period),
 		float64(period),
 		float64(yLog2),
+		minRunTime,
 		saturated / colorSteps,
-		bg,
-		2 * math.Pi / float64(period)})
+		themeOrDefault(theme),
+		2 * math.Pi / float64(period),
+		labels,
+		jitter,
+		byErrorClass,
+		nil,
+		nil})
 }
 
 // Record accepts an EventData pointer and plots it onto the visualization.
@@ -95,10 +112,12 @@ func (v *polarScatter) Record(e *EventData) {
 	// and quantization artifacts which could distract from real patterns or
 	// create a false sense of consistency in the run times of short-lived
 	// events./
-	t := float64(e.Run) + rand.NormFloat64() / 2
+	t := e.RunTime() + v.jitter.offset(e)
 
-	// Distance from center of visualization (for event run time).
-	r := v.yLog2 * math.Log2(t)
+	// Distance from center of visualization (for event run time). Run times
+	// shorter than the one at the center are plotted at the center, rather
+	// than being flung through it to the opposite side by a negative radius.
+	r := v.yLog2 * runTimeLog2(math.Max(v.tMin, t), v.tMin)
 
 	w, h := v.w, v.h
 
@@ -111,6 +130,8 @@ func (v *polarScatter) Record(e *EventData) {
 	var frame []float64
 	if e.Status == 0 {
 		frame = v.s
+	} else if e.Status > 0 && v.byClass {
+		frame = errorClassChannel(&v.classes, int(e.Status)-1, len(v.f))
 	} else if e.Status > 0 {
 		frame = v.f
 	} else {
@@ -134,40 +155,110 @@ func (v *polarScatter) Record(e *EventData) {
 	}
 }
 
+// Clone returns an empty polar-scatter-visualization generator with the same
+// configuration as this one.
+func (v *polarScatter) Clone() Visualizer {
+	c := *v
+	c.s = make([]float64, len(v.s))
+	c.f = make([]float64, len(v.f))
+	c.a = make([]float64, len(v.a))
+	c.classes = nil
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *polarScatter) Merge(o Visualizer) {
+	other := o.(*polarScatter)
+	addFloat64s(v.s, other.s)
+	addFloat64s(v.f, other.f)
+	addFloat64s(v.a, other.a)
+	mergeErrorClassChannels(&v.classes, other.classes, len(v.f))
+}
+
+// NameErrorClasses sets the names of the error classes shown in the legend
+// when failures are split by error class.
+func (v *polarScatter) NameErrorClasses(names []string) {
+	v.names = names
+}
+
 // Render returns the visualization constructed from all previously-recorded
 // data points.
 func (v *polarScatter) Render() image.Image {
 
 	// Create a normal image canvas to render to.
-	w, h, cΔ := v.w, v.h, v.cΔ
-	vis := initializeVisualization(w, h, v.bg)
+	w, h := v.w, v.h
+	vis := initializeVisualization(w, h, v.theme.Background)
 
 	// Draw crosshairs.
-	drawXGridLine(vis, v.w/2)
-	drawYGridLine(vis, v.h/2)
+	drawXGridLine(vis, v.w/2, v.theme.Grid)
+	drawYGridLine(vis, v.h/2, v.theme.Grid)
 
 	// Draw radial increments for time-period doublings.
 	for r := v.yLog2; int(r) < h || int(r) < w; r += v.yLog2 {
-		// This could be exchanged for drawPolarGridCircle(vis, r) if actual
-		// radial rings are desired for the grid rendering. Subjectively putting
-		// the two side-by-side, the crosshair ticks are less distracting and
-		// give a similar sense of where things fall on the time scale.
-		drawPolarGridRadialTicks(vis, r)
+		// This could be exchanged for drawPolarGridCircle(vis, r, v.theme.Grid)
+		// if actual radial rings are desired for the grid rendering.
+		// Subjectively putting the two side-by-side, the crosshair ticks are
+		// less distracting and give a similar sense of where things fall on the
+		// time scale.
+		drawPolarGridRadialTicks(vis, r, v.theme.Grid)
 	}
 
 	// Render point data to final image.
-	s, f, a := v.s, v.f, v.a
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := (y+2)*w + x + 2
-			if s[i] > 0 || f[i] > 0 || a[i] > 0 {
-				c := getRGBA(vis, x, y)
-				c.R = uint8(math.Min(saturated, float64(c.R)+(s[i]/4+f[i])*cΔ))
-				c.G = uint8(math.Min(saturated, float64(c.G)+(s[i]/4+a[i])*cΔ))
-				c.B = uint8(math.Min(saturated, float64(c.B)+(s[i])*cΔ))
-			}
-		}
+	v.plot(vis)
+
+	// Label the radial time scale and draw a legend on top of everything else.
+	if v.labels {
+		drawLabels(vis, polarRunTimeLabels(w, h, v.yLog2, v.tMin), v.theme)
+		drawLegend(vis, v.legend(), v.theme)
 	}
 
 	return vis
 }
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document. The point cloud stays raster, embedded as
+// an image between the vector grid and labels.
+func (v *polarScatter) RenderSVG(out io.Writer) error {
+
+	// Plot the point data onto a bare canvas, which will then be embedded as a
+	// raster image atop the vector grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.plot(vis)
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Draw crosshairs and radial increments for time-period doublings.
+	svg.xGridLine(v.w / 2)
+	svg.yGridLine(v.h / 2)
+	for r := v.yLog2; int(r) < v.h || int(r) < v.w; r += v.yLog2 {
+		svg.polarGridRadialTicks(r)
+	}
+
+	svg.pixels(vis)
+
+	if v.labels {
+		svg.labels(polarRunTimeLabels(v.w, v.h, v.yLog2, v.tMin))
+		svg.legend(v.legend())
+	}
+
+	return svg.close()
+}
+
+// Composite the point data onto an image, with failures split by error class
+// if requested.
+func (v *polarScatter) plot(vis *image.RGBA) {
+	if v.byClass {
+		plotErrorClassDensity(vis, v.theme, v.cΔ, v.s, v.classes, v.a)
+	} else {
+		plotDensity(vis, v.theme, v.cΔ, v.s, v.f, v.a)
+	}
+}
+
+// Get the color legend.
+func (v *polarScatter) legend() []legendEntry {
+	if v.byClass {
+		return errorClassDensityLegend(v.theme, v.classes, v.names)
+	}
+	return densityLegend(v.theme)
+}
@@ -0,0 +1,294 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Amount of the failure color's ink used to shade the region of the progress
+// visualization in which events are considered to be stuck.
+const stuckZoneInk = 0.12
+
+// Density plot of the progress of in-progress events against their elapsed run
+// times, for finding wedged events. Events which have run for at least the
+// stuck run time without getting past the stuck progress percentage are drawn
+// in the failure color over a shaded region, with everything else drawn in the
+// active color.
+//
+// Note that floating-point pre-rendering canvases have a two-pixel bleed on all
+// edges to allow for simple use of the bloom effect's convolution kernel.
+type progress struct {
+	w      int       // Width of the visualization
+	h      int       // Height of the visualization
+	a      []float64 // Channel for in-progress events
+	f      []float64 // Channel for stuck in-progress events
+	xLog2  float64   // Number of pixels over which elapsed times double
+	tMin   float64   // Run time at the origin of the run-time axis
+	cΔ     float64   // Increment for color channel value increases
+	stuckP int       // Progress percentage below which events may be stuck
+	stuckT float64   // Run time in seconds after which events may be stuck
+	stuck  int       // Count of stuck events recorded
+	theme  *Theme    // Colors to render the visualization with
+	labels bool      // Whether to draw axis labels and a legend
+	jitter *Jitter   // Source of random noise applied to run times
+}
+
+// NewProgress returns a progress-distribution-visualization generator, which
+// plots in-progress events by their progress percentage against their elapsed
+// run time. Events which have been running for at least stuckRunTime seconds
+// with less than stuckProgress percent progress are highlighted as stuck.
+func NewProgress(
+	width int,
+	height int,
+	theme *Theme,
+	xLog2 float64,
+	minRunTime float64,
+	colorSteps float64,
+	stuckProgress int,
+	stuckRunTime int,
+	labels bool,
+	jitter *Jitter) Visualizer {
+
+	return &progress{
+		width,
+		height,
+		make([]float64, (width+4)*(height+4)),
+		make([]float64, (width+4)*(height+4)),
+		xLog2,
+		minRunTime,
+		saturated / colorSteps,
+		stuckProgress,
+		float64(stuckRunTime),
+		0,
+		themeOrDefault(theme),
+		labels,
+		jitter}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *progress) Record(e *EventData) {
+
+	// Only in-progress events are of interest in this visualization.
+	if e.Status >= 0 {
+		return
+	}
+
+	// Apply a bit of random "noise" to the time scale, as is done for the
+	// scatter visualization, to avoid quantization artifacts in the run times
+	// of short-lived events.
+	t := e.RunTime() + v.jitter.offset(e)
+
+	p := intMin(int(e.Progress), 100)
+	xP := int(v.xLog2 * runTimeLog2(math.Max(v.tMin, t), v.tMin))
+	yP := v.progressY(p)
+
+	w, h := v.w, v.h
+
+	// Select appropriate canvas layer based on whether the event appears to be
+	// stuck.
+	frame := v.a
+	if v.isStuck(e.RunTime(), p) {
+		frame = v.f
+		v.stuck++
+	}
+
+	// Coordinates are translated to account for bleed on floating-point canvas
+	// and convolution kernel.
+	xMin, xMax := xP, xP+5
+	yMin, yMax := yP, yP+5
+	if xMin >= 2 && xMax < w+2 && yMin >= 2 && yMax < h+2 {
+		// Convolved plot point fits entirely within canvas (including its bleed
+		// zone), so we can safely draw it without falling off the edge.
+		iK := 0
+		for y := yMin; y < yMax; y++ {
+			for x := xMin; x < xMax; x++ {
+				frame[y*w+x] += pointConvolutionKernel[iK]
+				iK++
+			}
+		}
+	}
+}
+
+// Clone returns an empty progress-distribution-visualization generator with
+// the same configuration as this one.
+func (v *progress) Clone() Visualizer {
+	c := *v
+	c.a = make([]float64, len(v.a))
+	c.f = make([]float64, len(v.f))
+	c.stuck = 0
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *progress) Merge(o Visualizer) {
+	other := o.(*progress)
+	addFloat64s(v.a, other.a)
+	addFloat64s(v.f, other.f)
+	v.stuck += other.stuck
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *progress) Render() image.Image {
+
+	// Create a normal image canvas to render to.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+
+	// Shade the region in which events are considered to be stuck.
+	ink := v.theme.ink(v.theme.Failure)
+	zone := v.stuckZone()
+	for y := zone.Min.Y; y < zone.Max.Y; y++ {
+		for x := zone.Min.X; x < zone.Max.X; x++ {
+			tint(getRGBA(vis, x, y), ink, stuckZoneInk)
+		}
+	}
+
+	// Draw vertical grid lines on each doubling of the run time in seconds.
+	for x := v.xLog2; x < float64(v.w); x += v.xLog2 {
+		drawXGridLine(vis, int(x), v.theme.Grid)
+	}
+
+	// Draw horizontal grid lines on each quarter of progress.
+	for _, t := range v.progressTicks() {
+		drawYGridLine(vis, t.pos, v.theme.Grid)
+	}
+
+	// Render point data to final image.
+	plotDensity(vis, v.theme, v.cΔ, make([]float64, len(v.a)), v.f, v.a)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document. The point cloud stays raster, embedded as
+// an image between the vector grid and labels.
+func (v *progress) RenderSVG(out io.Writer) error {
+
+	// Plot the point data onto a bare canvas, which will then be embedded as a
+	// raster image atop the vector grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	plotDensity(vis, v.theme, v.cΔ, make([]float64, len(v.a)), v.f, v.a)
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Shade the region in which events are considered to be stuck.
+	zone := v.stuckZone()
+	svg.rect(
+		float64(zone.Min.X), float64(zone.Min.Y),
+		float64(zone.Dx()), float64(zone.Dy()),
+		v.theme.tinted(v.theme.Failure, stuckZoneInk))
+
+	// Draw vertical grid lines on each doubling of the run time in seconds.
+	for x := v.xLog2; x < float64(v.w); x += v.xLog2 {
+		svg.xGridLine(int(x))
+	}
+
+	// Draw horizontal grid lines on each quarter of progress.
+	for _, t := range v.progressTicks() {
+		svg.yGridLine(t.pos)
+	}
+
+	svg.pixels(vis)
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the density of the recorded in-progress events at each pixel of
+// the visualization, with stuck events kept apart from the rest, and with rows
+// binned by progress percentage.
+func (v *progress) Data() *SeriesData {
+	y := make([]float64, v.h)
+	for row := range y {
+		p := 100 - float64((row-2)*100)/float64(v.h-6)
+		y[row] = math.Min(math.Max(p, 0), 100)
+	}
+	return &SeriesData{
+		XAxis: RunTimeAxis,
+		X:     runTimeCoordinates(v.w, v.xLog2, v.tMin),
+		YAxis: ProgressAxis,
+		Y:     y,
+		Grids: map[string][][]float64{
+			"active": densityGrid(v.a, v.w, v.h),
+			"stuck":  densityGrid(v.f, v.w, v.h)}}
+}
+
+// Get the labels for the run-time and progress axes, and the color legend,
+// which includes the count of stuck events.
+func (v *progress) axisLabels() ([]label, []legendEntry) {
+	legend := []legendEntry{
+		{v.theme.tinted(v.theme.Active, 1), "active"},
+		{v.theme.tinted(v.theme.Failure, 1),
+			fmt.Sprintf("stuck: %d", v.stuck)}}
+	labels := runTimeLabelsX(v.w, v.h, v.xLog2, v.tMin)
+	labels = labelsLeftOf(labels, legendLeft(v.w, legend))
+	for _, t := range v.progressTicks() {
+		labels = append(
+			labels,
+			label{labelMargin, t.pos - glyphHeight - 1,
+				fmt.Sprintf("%.0f%%", t.value)})
+	}
+	return labels, legend
+}
+
+// Get the positions of the ticks on each quarter of progress.
+func (v *progress) progressTicks() []tick {
+	var ticks []tick
+	for p := 25; p < 100; p += 25 {
+		ticks = append(ticks, tick{v.progressY(p), float64(p)})
+	}
+	return ticks
+}
+
+// Get the region of the visualization in which events are considered to be
+// stuck.
+func (v *progress) stuckZone() image.Rectangle {
+	x := int(v.xLog2 * runTimeLog2(math.Max(v.tMin, v.stuckT), v.tMin))
+	y := v.progressY(v.stuckP)
+	return image.Rect(x, y, v.w, v.h).Intersect(image.Rect(0, 0, v.w, v.h))
+}
+
+// Get the y-position for the specified progress percentage. Positions are inset
+// from the top and bottom edges far enough for the plot points of events at
+// zero and full progress to fit within the canvas.
+func (v *progress) progressY(p int) int {
+	return 2 + (v.h-6)*(100-p)/100
+}
+
+// Whether an event with the specified run time and progress percentage is
+// considered to be stuck.
+func (v *progress) isStuck(t float64, p int) bool {
+	return t >= v.stuckT && p < v.stuckP
+}
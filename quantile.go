@@ -0,0 +1,128 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"math"
+)
+
+const (
+	sketchSteps   = 8   // Sketch buckets per doubling of the run time
+	sketchMinLog2 = -10 // Log2 of the smallest run time given its own bucket
+	sketchMaxLog2 = 32  // Log2 of the largest run time given its own bucket
+
+	// Total bucket count, including a bucket for zero (and negative) run times
+	// and the open-ended buckets at either end of the range.
+	sketchBuckets = (sketchMaxLog2-sketchMinLog2)*sketchSteps + 2
+)
+
+// Bounded-memory quantile sketch for run times. Values are counted in buckets
+// of fixed relative width along the same log2 scale the visualizations use for
+// run times, so any quantile can be estimated to within 1/sketchSteps of a
+// doubling (about 9% relative error) regardless of how many values have been
+// added. Sketches of the same layout can be combined by summing their buckets.
+type quantileSketch struct {
+	counts [sketchBuckets]uint32 // Counts of values by bucket
+	n      uint64                // Total count of values in the sketch
+}
+
+// Add a run time, in seconds, to the sketch.
+func (q *quantileSketch) add(t float64) {
+	q.counts[sketchBucket(t)]++
+	q.n++
+}
+
+// Fold the contents of another sketch into this one.
+func (q *quantileSketch) merge(o *quantileSketch) {
+	for i := range q.counts {
+		q.counts[i] += o.counts[i]
+	}
+	q.n += o.n
+}
+
+// Estimate the value at the specified quantile (in the range [0, 1]) of the
+// values added to the sketch. NaN is returned for an empty sketch.
+func (q *quantileSketch) quantile(p float64) float64 {
+	if q.n == 0 {
+		return math.NaN()
+	}
+	rank := uint64(math.Ceil(p * float64(q.n)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := uint64(0)
+	for i, c := range q.counts {
+		seen += uint64(c)
+		if seen >= rank {
+			return sketchValue(i)
+		}
+	}
+	return sketchValue(sketchBuckets - 1)
+}
+
+// Get the smallest and largest of the values added to the sketch which lie
+// within the specified bounds, along with the counts of values below and above
+// them. Values are compared by the representative run times of their buckets.
+// NaN is returned for the extent if no values lie within the bounds.
+func (q *quantileSketch) extent(
+	lo float64,
+	hi float64) (min float64, max float64, below uint64, above uint64) {
+
+	min, max = math.NaN(), math.NaN()
+	for i, c := range q.counts {
+		if c == 0 {
+			continue
+		}
+		switch t := sketchValue(i); {
+		case t < lo:
+			below += uint64(c)
+		case t > hi:
+			above += uint64(c)
+		default:
+			if math.IsNaN(min) {
+				min = t
+			}
+			max = t
+		}
+	}
+	return min, max, below, above
+}
+
+// Get the bucket index for the specified run time.
+func sketchBucket(t float64) int {
+	if t <= 0 {
+		return 0
+	}
+	i := int(math.Floor((math.Log2(t)-sketchMinLog2)*sketchSteps)) + 1
+	if i < 1 {
+		return 1
+	}
+	if i > sketchBuckets-1 {
+		return sketchBuckets - 1
+	}
+	return i
+}
+
+// Get the representative run time for a bucket, which is the geometric
+// midpoint of the range of values it covers.
+func sketchValue(i int) float64 {
+	if i == 0 {
+		return 0
+	}
+	return math.Exp2(sketchMinLog2 + (float64(i)-0.5)/sketchSteps)
+}
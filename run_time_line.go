@@ -19,32 +19,43 @@ package perspective
 
 import (
 	"image"
+	"image/color"
+	"io"
 	"math"
 )
 
+// Amount of ink applied by each stroke of the line, leaving room for crossing
+// strokes to build up in intensity.
+const lineInk = 0.625
+
 type runTimeLine struct {
-	w         int     // Width of the visualization
-	h         int     // Height of the visualization
-	tA        float64 // Lower limit of time range to be visualized
-	tτ        float64 // Length of time range to be visualized
-	yLog2     float64 // Number of pixels over which elapsed times double
-	nS        []int   // Counts of successful events by x-axis position
-	nF        []int   // Counts of failed events by x-axis position
-	nA        []int   // Counts of active events by x-axis position
-	t         []int   // Sums of run-times of events by x-position
-	xGrid     int     // Number of vertical grid divisions
-	bg        int     // Background grey level
+	w      int          // Width of the visualization
+	h      int          // Height of the visualization
+	tA     float64      // Lower limit of time range to be visualized
+	tτ     float64      // Length of time range to be visualized
+	yLog2  float64      // Number of pixels over which elapsed times double
+	tMin   float64      // Run time at the origin of the run-time axis
+	nS     []int        // Counts of successful events by x-axis position
+	nF     []int        // Counts of failed events by x-axis position
+	nA     []int        // Counts of active events by x-axis position
+	t      []float64    // Sums of run-times of events by x-position
+	xGrid  int          // Number of vertical grid divisions
+	theme  *Theme       // Colors to render the visualization with
+	labels bool         // Whether to draw axis labels and a legend
+	notes  []Annotation // Annotations to draw over the time axis
 }
 
 // NewRunTimeLine returns an line-graph event-run-time-visualization generator.
 func NewRunTimeLine(
 	width int,
 	height int,
-	bg int,
+	theme *Theme,
 	minTime int,
 	maxTime int,
 	yLog2 float64,
-	xGrid int) Visualizer {
+	minRunTime float64,
+	xGrid int,
+	labels bool) Visualizer {
 
 	return &runTimeLine{
 		width,
@@ -52,12 +63,15 @@ func NewRunTimeLine(
 		float64(minTime),
 		float64(maxTime - minTime),
 		yLog2,
+		minRunTime,
 		make([]int, width),
 		make([]int, width),
 		make([]int, width),
-		make([]int, width),
+		make([]float64, width),
 		xGrid,
-		bg}
+		themeOrDefault(theme),
+		labels,
+		nil}
 }
 
 // Record accepts an EventData pointer and plots it onto the visualization.
@@ -74,7 +88,33 @@ func (v *runTimeLine) Record(e *EventData) {
 	} else {
 		v.nA[x]++
 	}
-	v.t[x] = v.t[x] + int(e.Run)
+	v.t[x] = v.t[x] + e.RunTime()
+}
+
+// Clone returns an empty run-time-line-visualization generator with the same
+// configuration as this one.
+func (v *runTimeLine) Clone() Visualizer {
+	c := *v
+	c.nS = make([]int, len(v.nS))
+	c.nF = make([]int, len(v.nF))
+	c.nA = make([]int, len(v.nA))
+	c.t = make([]float64, len(v.t))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *runTimeLine) Merge(o Visualizer) {
+	other := o.(*runTimeLine)
+	addInts(v.nS, other.nS)
+	addInts(v.nF, other.nF)
+	addInts(v.nA, other.nA)
+	addFloat64s(v.t, other.t)
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *runTimeLine) Annotate(notes []Annotation) {
+	v.notes = notes
 }
 
 // Render returns the visualization constructed from all previously-recorded
@@ -85,19 +125,14 @@ func (v *runTimeLine) Render() image.Image {
 	stroke := v.h / 48
 
 	// Initialize our image canvas and grid.
-	vis := initializeVisualization(v.w, v.h, v.bg)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
 	v.drawGrid(vis)
 
 	// Draw the lines.
 	xLast, yLast := 0, 0;
 	for x := 0; x < v.w; x++ {
 
-		// We only calculate logs on source time values which exceed 1 in order
-		// to put a floor value of zero on the output value.
-		y := 0
-		n := v.nS[x] + v.nF[x] + v.nA[x]
-		this := float64(v.t[x])/math.Max(float64(n), 1)
-		if this > 1 { y = int(v.yLog2*math.Log2(this)) }
+		n, y := v.meanY(x)
 
 		// Color line according to relative quantities of completed, failed, and
 		// successful events recorded at during the time range corresponding to
@@ -112,9 +147,7 @@ func (v *runTimeLine) Render() image.Image {
 				xIncrement = 4
 			}
 
-			r := uint8(32 + 128 * v.nF[x] / n)
-			g := uint8(32 + 128 * v.nA[x] / n)
-			b := uint8(32 + 128 * v.nS[x] / n)
+			ink := v.lineInk(x)
 
 			for xPos := xLast; xPos < x; xPos += xIncrement {
 				var yMin, yMax int
@@ -126,10 +159,7 @@ func (v *runTimeLine) Render() image.Image {
 					yMin, yMax = yB, yA
 				}
 				for yPos := yMin; yPos <= yMax + stroke; yPos++ {
-					c := getRGBA(vis, xPos, v.h-yPos)
-					c.R += r
-					c.G += g
-					c.B += b
+					tint(getRGBA(vis, xPos, v.h-yPos), ink, 1)
 				}
 			}
 
@@ -140,33 +170,237 @@ func (v *runTimeLine) Render() image.Image {
 
 	// Flatline data from last data point out to end of graph, and make line
 	// dotted after real data has ceased to be available.
-	n := v.nS[xLast] + v.nF[xLast] + v.nA[xLast]
-	r := uint8(32 + 128 * v.nF[xLast] / n)
-	g := uint8(32 + 128 * v.nA[xLast] / n)
-	b := uint8(32 + 128 * v.nS[xLast] / n)
+	ink := v.lineInk(xLast)
 	for x := xLast; x < v.w; x += 4 {
 		for yPos := yLast; yPos <= yLast + stroke; yPos++ {
-			c := getRGBA(vis, x, v.h-yPos)
-			c.R += r
-			c.G += g
-			c.B += b
+			tint(getRGBA(vis, x, v.h-yPos), ink, 1)
 		}
 	}
 
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
 	return vis
 }
 
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *runTimeLine) RenderSVG(out io.Writer) error {
+
+	// Stroke width (for visibility and calligraphic effect)
+	stroke := float64(v.h / 48)
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	// Draw vertical grid lines, if vertical divisions were specified.
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+
+	// Draw horizontal grid lines on each doubling of the run time in seconds.
+	for y := float64(v.h); y > 0; y -= v.yLog2 {
+		svg.yGridLine(int(y))
+	}
+
+	// Draw the lines, offset by half of the stroke width so they sit along the
+	// same baseline as the raster rendering.
+	yOf := func(y int) float64 { return float64(v.h-y) - stroke/2 }
+	xLast, yLast := 0, 0
+	for x := 0; x < v.w; x++ {
+		n, y := v.meanY(x)
+		if n > 0 {
+			// Flatline data from beginning of graph up to first data point, and
+			// make line dotted until real data is available.
+			dashed := xLast == 0
+			if dashed {
+				yLast = y
+			}
+			svg.line(
+				float64(xLast), yOf(yLast), float64(x), yOf(y),
+				v.svgLineColor(x), stroke+1, dashed)
+			yLast = y
+			xLast = x
+		}
+	}
+
+	// Flatline data from last data point out to end of graph, and make line
+	// dotted after real data has ceased to be available.
+	if n, _ := v.meanY(xLast); n > 0 {
+		svg.line(
+			float64(xLast), yOf(yLast), float64(v.w), yOf(yLast),
+			v.svgLineColor(xLast), stroke+1, true)
+	}
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// RenderText writes the visualization constructed from all previously-recorded
+// data points out as a colored sparkline for display in a terminal, with one
+// character for each pixel of the visualization's width. As is usual for a
+// sparkline, its height spans only the range of mean run times which were
+// actually recorded, and that range is given along with the legend.
+func (v *runTimeLine) RenderText(out io.Writer) error {
+
+	text := newTextWriter(out, v.w)
+
+	yMin, yMax := math.MaxInt32, 0
+	for x := 0; x < v.w; x++ {
+		if n, y := v.meanY(x); n > 0 {
+			yMin, yMax = intMin(yMin, y), intMax(yMax, y)
+		}
+	}
+	values := make([]float64, v.w)
+	for x := range values {
+		if n, y := v.meanY(x); n > 0 {
+			values[x] = float64(y - yMin + 1)
+		}
+	}
+	rows := intMax(1, v.h/cellHeight)
+	scale := float64(rows*cellHeight) / float64(yMax-yMin+1)
+	text.sparkline(values, scale, rows, v.mixedColor)
+
+	if v.labels {
+		text.axis(textTimeLabels(v.w, v.tA, v.tτ))
+		text.legend([]legendEntry{
+			{v.theme.Success, "success"},
+			{v.theme.Failure, "failure"},
+			{v.theme.Active, "active"}})
+		if yMin <= yMax {
+			text.printf(
+				"mean run time %s to %s\n",
+				formatRunTime(v.tMin*math.Exp2(float64(yMin)/v.yLog2)),
+				formatRunTime(v.tMin*math.Exp2(float64(yMax)/v.yLog2)))
+		}
+	}
+
+	return text.close()
+}
+
+// Data returns the counts of events by status at each x-position, along with
+// the mean run time in seconds of the events at that position.
+func (v *runTimeLine) Data() *SeriesData {
+	mean := make([]float64, v.w)
+	for x := range mean {
+		if n := v.nS[x] + v.nF[x] + v.nA[x]; n > 0 {
+			mean[x] = v.t[x] / float64(n)
+		}
+	}
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     timeCoordinates(v.w, v.tA, v.tτ),
+		Series: map[string][]float64{
+			"success":  float64s(v.nS),
+			"failure":  float64s(v.nF),
+			"active":   float64s(v.nA),
+			"mean-run": mean}}
+}
+
+// Get the labels for the time and run-time axes, and the color legend. Since
+// the line's color is a blend of these according to the mix of event statuses
+// at each point, the legend shows the color of a line for each pure status.
+func (v *runTimeLine) axisLabels() ([]label, []legendEntry) {
+	labels := timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ)
+	labels = append(labels, runTimeLabels(v.h, v.yLog2, v.tMin)...)
+	inks := v.theme.lineInks()
+	legend := []legendEntry{
+		{v.theme.inked(inks[0]), "success"},
+		{v.theme.inked(inks[1]), "failure"},
+		{v.theme.inked(inks[2]), "active"}}
+	return labels, legend
+}
+
+// Get the count of events recorded at the specified x-position, along with
+// the height of the line representing their mean run time. We only calculate
+// logs on source time values which exceed the run time at the origin of the
+// axis in order to put a floor value of zero on the output value.
+func (v *runTimeLine) meanY(x int) (n int, y int) {
+	n = v.nS[x] + v.nF[x] + v.nA[x]
+	mean := v.t[x] / math.Max(float64(n), 1)
+	if mean > v.tMin {
+		y = int(v.yLog2 * runTimeLog2(mean, v.tMin))
+	}
+	return n, y
+}
+
+// Color line according to relative quantities of completed, failed, and
+// successful events recorded at during the time range corresponding to the
+// specified x-position. The value is returned as an ink mixed from the theme's
+// line inks, to be applied over the existing color of the canvas.
+func (v *runTimeLine) lineInk(x int) (ink [3]float64) {
+	n := float64(v.nS[x] + v.nF[x] + v.nA[x])
+	if n == 0 {
+		return ink
+	}
+	inks := v.theme.lineInks()
+	for s, count := range []int{v.nS[x], v.nF[x], v.nA[x]} {
+		for i := range ink {
+			ink[i] += inks[s][i] * float64(count) / n
+		}
+	}
+	return ink
+}
+
+// Get the mix of the theme's status colors in proportion to the quantities of
+// events of each status recorded at the specified x-position, for renderers
+// which can't draw over a background.
+func (v *runTimeLine) mixedColor(x int) color.RGBA {
+	n := float64(v.nS[x] + v.nF[x] + v.nA[x])
+	var mix [3]float64
+	for _, m := range []struct {
+		c color.RGBA
+		n int
+	}{
+		{v.theme.Success, v.nS[x]},
+		{v.theme.Failure, v.nF[x]},
+		{v.theme.Active, v.nA[x]},
+	} {
+		f := float64(m.n) / math.Max(n, 1)
+		mix[0] += float64(m.c.R) * f
+		mix[1] += float64(m.c.G) * f
+		mix[2] += float64(m.c.B) * f
+	}
+	return color.RGBA{
+		clampChannel(mix[0]),
+		clampChannel(mix[1]),
+		clampChannel(mix[2]),
+		opaque}
+}
+
+// Get the line color for the specified x-position as an absolute color value,
+// for renderers which can't add to the existing color of the canvas.
+func (v *runTimeLine) svgLineColor(x int) color.RGBA {
+	return v.theme.inked(v.lineInk(x))
+}
+
 func (v *runTimeLine) drawGrid(vis *image.RGBA) {
 
 	// Draw vertical grid lines, if vertical divisions were specified.
 	if v.xGrid > 0 {
 		for i := 1; i < v.xGrid; i++ {
-			drawXGridLine(vis, i*v.w/v.xGrid)
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
 		}
 	}
 
 	// Draw horizontal grid lines on each doubling of the run time in seconds.
 	for y := float64(v.h); y > 0; y -= v.yLog2 {
-		drawYGridLine(vis, int(y))
+		drawYGridLine(vis, int(y), v.theme.Grid)
 	}
 }
@@ -19,36 +19,49 @@ package perspective
 
 import (
 	"image"
+	"io"
 	"math"
-	"math/rand"
 )
 
 // Note that floating-point pre-rendering canvases have a two-pixel bleed on all
 // edges to allow for simple use of the bloom effect's convolution kernel.
 type scatter struct {
-	w     int       // Width of the visualization
-	h     int       // Height of the visualization
-	s     []float64 // Channel for successful events
-	f     []float64 // Channel for failed events
-	a     []float64 // Channel for active events
-	tA    float64   // Lower limit of time range to be visualized
-	tτ    float64   // Length of time range to be visualized
-	yLog2 float64   // Number of pixels over which elapsed times double
-	cΔ    float64   // Increment for color channel value increases
-	xGrid int       // Number of vertical grid divisions
-	bg    int       // Background gray level
+	w       int          // Width of the visualization
+	h       int          // Height of the visualization
+	s       []float64    // Channel for successful events
+	f       []float64    // Channel for failed events
+	a       []float64    // Channel for active events
+	tA      float64      // Lower limit of time range to be visualized
+	tτ      float64      // Length of time range to be visualized
+	yLog2   float64      // Number of pixels over which elapsed times double
+	tMin    float64      // Run time at the origin of the run-time axis
+	cΔ      float64      // Increment for color channel value increases
+	xGrid   int          // Number of vertical grid divisions
+	theme   *Theme       // Colors to render the visualization with
+	labels  bool         // Whether to draw axis labels and a legend
+	jitter  *Jitter      // Source of random noise applied to run times
+	notes   []Annotation // Annotations to draw over the time axis
+	byClass bool         // Whether to split failures by error class
+	classes [][]float64  // Channels for failed events by error class
+	names   []string     // Names of error classes for the legend
 }
 
-// NewScatter returns a floating-point scatter-visualization generator.
+// NewScatter returns a floating-point scatter-visualization generator. If
+// byErrorClass is set, failed events are plotted in a distinct hue for each
+// error class rather than all in the failure color.
 func NewScatter(
 	width int,
 	height int,
-	bg int,
+	theme *Theme,
 	minTime int,
 	maxTime int,
 	yLog2 float64,
+	minRunTime float64,
 	colorSteps float64,
-	xGrid int) Visualizer {
+	xGrid int,
+	byErrorClass bool,
+	labels bool,
+	jitter *Jitter) Visualizer {
 
 	return (&scatter{
 		width,
@@ -59,9 +72,16 @@ func NewScatter(
 		float64(minTime),
 		float64(maxTime - minTime),
 		float64(yLog2),
+		minRunTime,
 		saturated / colorSteps,
 		xGrid,
-		bg})
+		themeOrDefault(theme),
+		labels,
+		jitter,
+		nil,
+		byErrorClass,
+		nil,
+		nil})
 }
 
 // Record accepts an EventData pointer and plots it onto the visualization.
@@ -72,10 +92,10 @@ func (v *scatter) Record(e *EventData) {
 	// and quantization artifacts which could distract from real patterns or
 	// create a false sense of consistency in the run times of short-lived
 	// events.
-	t := float64(e.Run) + rand.NormFloat64() / 2
+	t := e.RunTime() + v.jitter.offset(e)
 
 	xP := int(float64(v.w) * (float64(e.Start) - v.tA) / v.tτ)
-	yP := v.h - int(v.yLog2*math.Log2(t))
+	yP := v.h - int(v.yLog2*runTimeLog2(t, v.tMin))
 
 	w, h := v.w, v.h
 
@@ -83,6 +103,8 @@ func (v *scatter) Record(e *EventData) {
 	var frame []float64
 	if e.Status == 0 {
 		frame = v.s
+	} else if e.Status > 0 && v.byClass {
+		frame = errorClassChannel(&v.classes, int(e.Status)-1, len(v.f))
 	} else if e.Status > 0 {
 		frame = v.f
 	} else {
@@ -106,39 +128,164 @@ func (v *scatter) Record(e *EventData) {
 	}
 }
 
+// Clone returns an empty scatter-visualization generator with the same
+// configuration as this one.
+func (v *scatter) Clone() Visualizer {
+	c := *v
+	c.s = make([]float64, len(v.s))
+	c.f = make([]float64, len(v.f))
+	c.a = make([]float64, len(v.a))
+	c.classes = nil
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *scatter) Merge(o Visualizer) {
+	other := o.(*scatter)
+	addFloat64s(v.s, other.s)
+	addFloat64s(v.f, other.f)
+	addFloat64s(v.a, other.a)
+	mergeErrorClassChannels(&v.classes, other.classes, len(v.f))
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *scatter) Annotate(notes []Annotation) {
+	v.notes = notes
+}
+
+// NameErrorClasses sets the names of the error classes shown in the legend
+// when failures are split by error class.
+func (v *scatter) NameErrorClasses(names []string) {
+	v.names = names
+}
+
 // Render returns the visualization constructed from all previously-recorded
 // data points.
 func (v *scatter) Render() image.Image {
 
 	// Create a normal image canvas to render to.
-	w, h, cΔ := v.w, v.h, v.cΔ
-	vis := initializeVisualization(w, h, v.bg)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.drawGrid(vis)
+
+	// Render point data to final image.
+	v.plot(vis)
+
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document. The point cloud stays raster, embedded as
+// an image between the vector grid and labels.
+func (v *scatter) RenderSVG(out io.Writer) error {
+
+	// Plot the point data onto a bare canvas, which will then be embedded as a
+	// raster image atop the vector grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	v.plot(vis)
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+
+	v.drawSVGGrid(svg)
+	svg.pixels(vis)
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the density of the recorded data points at each pixel of the
+// visualization, by event status, with rows binned by run time.
+func (v *scatter) Data() *SeriesData {
+	y := make([]float64, v.h)
+	for row := range y {
+		y[row] = v.tMin * math.Exp2(float64(v.h-row)/v.yLog2)
+	}
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     timeCoordinates(v.w, v.tA, v.tτ),
+		YAxis: RunTimeAxis,
+		Y:     y,
+		Grids: map[string][][]float64{
+			"success": densityGrid(v.s, v.w, v.h),
+			"failure": densityGrid(v.failures(), v.w, v.h),
+			"active":  densityGrid(v.a, v.w, v.h)}}
+}
+
+// Get the labels for the time and run-time axes, and the color legend.
+func (v *scatter) axisLabels() ([]label, []legendEntry) {
+	labels := timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ)
+	labels = append(labels, runTimeLabels(v.h, v.yLog2, v.tMin)...)
+	if v.byClass {
+		return labels, errorClassDensityLegend(v.theme, v.classes, v.names)
+	}
+	return labels, densityLegend(v.theme)
+}
+
+// Composite the point data onto an image, with failures split by error class
+// if requested.
+func (v *scatter) plot(vis *image.RGBA) {
+	if v.byClass {
+		plotErrorClassDensity(vis, v.theme, v.cΔ, v.s, v.classes, v.a)
+	} else {
+		plotDensity(vis, v.theme, v.cΔ, v.s, v.f, v.a)
+	}
+}
+
+// Get the channel for all failed events, summing the error-class channels if
+// failures have been split by error class.
+func (v *scatter) failures() []float64 {
+	if v.byClass {
+		return sumErrorClassChannels(v.classes, len(v.f))
+	}
+	return v.f
+}
+
+func (v *scatter) drawGrid(vis *image.RGBA) {
 
 	// Draw vertical grid lines, if vertical divisions were specified.
 	if v.xGrid > 0 {
 		for i := 1; i < v.xGrid; i++ {
-			drawXGridLine(vis, i*w/v.xGrid)
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
 		}
 	}
 
 	// Draw horizontal grid lines on each doubling of the run time in seconds.
-	for y := float64(h); y > 0; y -= v.yLog2 {
-		drawYGridLine(vis, int(y))
+	for y := float64(v.h); y > 0; y -= v.yLog2 {
+		drawYGridLine(vis, int(y), v.theme.Grid)
 	}
+}
 
-	// Render point data to final image.
-	s, f, a := v.s, v.f, v.a
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := (y+2)*w + x + 2
-			if s[i] > 0 || f[i] > 0 || a[i] > 0 {
-				c := getRGBA(vis, x, y)
-				c.R = uint8(math.Min(saturated, float64(c.R)+(s[i]/4+f[i])*cΔ))
-				c.G = uint8(math.Min(saturated, float64(c.G)+(s[i]/4+a[i])*cΔ))
-				c.B = uint8(math.Min(saturated, float64(c.B)+(s[i])*cΔ))
-			}
+// Vector equivalent of drawGrid.
+func (v *scatter) drawSVGGrid(svg *svgWriter) {
+
+	// Draw vertical grid lines, if vertical divisions were specified.
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
 		}
 	}
 
-	return vis
+	// Draw horizontal grid lines on each doubling of the run time in seconds.
+	for y := float64(v.h); y > 0; y -= v.yLog2 {
+		svg.yGridLine(int(y))
+	}
 }
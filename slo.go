@@ -0,0 +1,484 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Opacity of the area filled between the error-budget line and zero.
+const budgetAreaOpacity = 0.4
+
+// SLO is a service-level objective for the success of completed events: the
+// target ratio of successful events to all completed events, held over a
+// trailing window of time given in seconds, for events of a type and region.
+// Type and Region are -1 to include events of any type or region. Events are
+// placed in time by their start times, as they are by the time filters.
+type SLO struct {
+	Target float64 `json:"target"`
+	Window int     `json:"window"`
+	Type   int     `json:"type"`
+	Region int     `json:"region"`
+}
+
+// Validate returns an error if the target is not strictly between zero and
+// one, or if the window is not a positive length of time.
+func (s SLO) Validate() error {
+	if !(s.Target > 0 && s.Target < 1) {
+		return fmt.Errorf("SLO target out of range: %g", s.Target)
+	}
+	if s.Window <= 0 {
+		return fmt.Errorf("SLO window out of range: %d", s.Window)
+	}
+	return nil
+}
+
+// Whether an event has completed, and is within the scope of the SLO.
+func (s SLO) covers(e *EventData) bool {
+	return e.Status >= 0 &&
+		(s.Type < 0 || int(e.Type) == s.Type) &&
+		(s.Region < 0 || int(e.Region) == s.Region)
+}
+
+// Get the rate at which the specified counts of events spend the error budget,
+// as a multiple of the rate which would spend exactly the whole budget over
+// the SLO's window. No budget is spent where no events completed.
+func (s SLO) burnRate(pass int, fail int) float64 {
+	if pass+fail == 0 {
+		return 0
+	}
+	return float64(fail) / float64(pass+fail) / (1 - s.Target)
+}
+
+// BurnRate is the rate at which the error budget of an SLO was spent over a
+// trailing window of time given in seconds, as a multiple of the rate which
+// would spend exactly the whole budget over the SLO's own window. Rates well
+// above one over short windows are the usual signal for paging, and rates
+// just above one over long windows for ticketing.
+type BurnRate struct {
+	Window  int     `json:"window"`
+	Success int     `json:"success"`
+	Failure int     `json:"failure"`
+	Rate    float64 `json:"rate"`
+}
+
+// SLOReport is the state of the error budget of an SLO at a point in time. The
+// budget is the number of failures the target allows among the events which
+// completed over the SLO's window, and the fraction of it remaining goes
+// negative once the budget has been overspent.
+type SLOReport struct {
+	SLO       SLO        `json:"slo"`
+	Time      int        `json:"time"`
+	Success   int        `json:"success"`
+	Failure   int        `json:"failure"`
+	Budget    float64    `json:"budget"`
+	Remaining float64    `json:"remaining"`
+	BurnRates []BurnRate `json:"burnRates"`
+}
+
+// SLOTracker counts the events recorded into it toward a report on an SLO as
+// of a point in time, and toward its burn rates over each of a set of windows
+// ending at that time. Events which started at or after that time, or before
+// the longest of the windows, are ignored.
+type SLOTracker struct {
+	slo   SLO        // Objective being reported on
+	t     int        // Time as of which to report, in Unix epoch time
+	pass  int        // Count of successful events over the SLO's window
+	fail  int        // Count of failed events over the SLO's window
+	burns []BurnRate // Counts of events over each burn-rate window
+}
+
+// NewSLOTracker returns a tracker for a report on an SLO as of the specified
+// time, with burn rates over windows of the specified lengths in seconds.
+func NewSLOTracker(slo SLO, at int, burnWindows []int) *SLOTracker {
+	burns := make([]BurnRate, len(burnWindows))
+	for i, window := range burnWindows {
+		burns[i].Window = window
+	}
+	return &SLOTracker{slo, at, 0, 0, burns}
+}
+
+// Record accepts an EventData pointer and counts it toward the report.
+func (t *SLOTracker) Record(e *EventData) {
+	age := t.t - int(e.Start)
+	if age <= 0 || !t.slo.covers(e) {
+		return
+	}
+	if age <= t.slo.Window {
+		if e.Status == 0 {
+			t.pass++
+		} else {
+			t.fail++
+		}
+	}
+	for i := range t.burns {
+		if age <= t.burns[i].Window {
+			if e.Status == 0 {
+				t.burns[i].Success++
+			} else {
+				t.burns[i].Failure++
+			}
+		}
+	}
+}
+
+// Report returns the report on the SLO from the events recorded so far.
+func (t *SLOTracker) Report() SLOReport {
+	burns := make([]BurnRate, len(t.burns))
+	for i, b := range t.burns {
+		b.Rate = t.slo.burnRate(b.Success, b.Failure)
+		burns[i] = b
+	}
+	return SLOReport{
+		t.slo,
+		t.t,
+		t.pass,
+		t.fail,
+		(1 - t.slo.Target) * float64(t.pass+t.fail),
+		1 - t.slo.burnRate(t.pass, t.fail),
+		burns}
+}
+
+// Error-budget visualization of an SLO, plotting the fraction of the error
+// budget remaining at each point in time, as it would be reported at that
+// time over the SLO's trailing window. The area between the budget line and
+// zero is filled in the success color while budget remains, and in the
+// failure color where it has been overspent. The budget-remaining axis runs
+// from 100% at the top down to zero, or further to as low as -100% if the
+// budget was overspent, with overspending beyond that clamped.
+type errorBudget struct {
+	w      int          // Width of the visualization
+	h      int          // Height of the visualization
+	tA     float64      // Lower limit of time range to be visualized
+	tτ     float64      // Length of time range to be visualized
+	slo    SLO          // Objective whose error budget is visualized
+	k      int          // Number of x-positions spanned by the SLO's window
+	pass   []int        // Counts of successful events by x-position
+	fail   []int        // Counts of failed events by x-position
+	xGrid  int          // Number of vertical grid divisions
+	theme  *Theme       // Colors to render the visualization with
+	labels bool         // Whether to draw axis labels and a legend
+	notes  []Annotation // Annotations to draw over the time axis
+}
+
+// NewErrorBudget returns an error-budget-visualization generator for an SLO.
+// Events starting over the SLO's window before the time range count toward
+// the budget at its start, so they should be recorded as well; they are not
+// otherwise drawn. The window is rounded to a whole number of x-positions.
+func NewErrorBudget(
+	width int,
+	height int,
+	theme *Theme,
+	minTime int,
+	maxTime int,
+	slo SLO,
+	xGrid int,
+	labels bool) Visualizer {
+
+	tτ := float64(maxTime - minTime)
+	k := intMax(1, int(math.Round(float64(slo.Window)*float64(width)/tτ)))
+
+	return &errorBudget{
+		width,
+		height,
+		float64(minTime),
+		tτ,
+		slo,
+		k,
+		make([]int, k+width),
+		make([]int, k+width),
+		xGrid,
+		themeOrDefault(theme),
+		labels,
+		nil}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *errorBudget) Record(e *EventData) {
+
+	// Counts for the x-positions of the SLO window preceding the time range
+	// are held ahead of those for the time range itself.
+	x := math.Floor(float64(v.w) * (float64(e.Start) - v.tA) / v.tτ)
+	i := int(x) + v.k
+	if x < float64(-v.k) || i >= len(v.pass) || !v.slo.covers(e) {
+		return
+	}
+	if e.Status == 0 {
+		v.pass[i]++
+	} else {
+		v.fail[i]++
+	}
+}
+
+// Clone returns an empty error-budget-visualization generator with the same
+// configuration as this one.
+func (v *errorBudget) Clone() Visualizer {
+	c := *v
+	c.pass = make([]int, len(v.pass))
+	c.fail = make([]int, len(v.fail))
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *errorBudget) Merge(o Visualizer) {
+	other := o.(*errorBudget)
+	addInts(v.pass, other.pass)
+	addInts(v.fail, other.fail)
+}
+
+// Annotate sets the annotations to draw over the time axis of the
+// visualization, marking external events such as deploys and incidents.
+func (v *errorBudget) Annotate(notes []Annotation) {
+	v.notes = notes
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *errorBudget) Render() image.Image {
+
+	// Initialize our image canvas and grid.
+	remaining := v.remaining()
+	lo := v.floor(remaining)
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			drawXGridLine(vis, i*v.w/v.xGrid, v.theme.Grid)
+		}
+	}
+	ticks := v.ticks(lo)
+	for _, t := range ticks {
+		drawYGridLine(vis, t.pos, v.theme.Grid)
+	}
+
+	// Fill the area between the budget line and zero, then draw the line
+	// itself over it.
+	stroke := intMax(1, v.h/96)
+	y0 := v.y(0, lo)
+	for x, r := range remaining {
+		c := v.budgetColor(r)
+		area := blend(v.theme.Background, c, budgetAreaOpacity)
+		y := v.y(r, lo)
+		for yPos := intMin(y, y0); yPos <= intMax(y, y0); yPos++ {
+			*getRGBA(vis, x, yPos) = area
+		}
+		for yPos := y - stroke/2; yPos <= y+(stroke-1)/2; yPos++ {
+			*getRGBA(vis, x, yPos) = c
+		}
+	}
+
+	// Mark annotated external events over the data.
+	drawAnnotations(
+		vis, annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme), v.theme)
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels(ticks)
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *errorBudget) RenderSVG(out io.Writer) error {
+
+	remaining := v.remaining()
+	lo := v.floor(remaining)
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	if v.xGrid > 0 {
+		for i := 1; i < v.xGrid; i++ {
+			svg.xGridLine(i * v.w / v.xGrid)
+		}
+	}
+	ticks := v.ticks(lo)
+	for _, t := range ticks {
+		svg.yGridLine(t.pos)
+	}
+
+	// Trace the areas above and below zero as separate polygons, each running
+	// along the budget line (clipped to its side of zero) and back along zero,
+	// then trace the budget line over them in segments of a single color.
+	y0 := float64(v.y(0, lo))
+	for _, above := range []bool{true, false} {
+		var points []float64
+		for x, r := range remaining {
+			if (r >= 0) != above {
+				r = 0
+			}
+			y := float64(v.y(r, lo))
+			points = append(points, float64(x), y, float64(x+1), y)
+		}
+		c := v.budgetColor(-1)
+		if above {
+			c = v.budgetColor(1)
+		}
+		points = append(points, float64(v.w), y0, 0, y0)
+		svg.polygon(points, blend(v.theme.Background, c, budgetAreaOpacity))
+	}
+	stroke := float64(intMax(1, v.h/96))
+	var points []float64
+	for x, r := range remaining {
+		y := float64(v.y(r, lo)) + 0.5
+		points = append(points, float64(x), y, float64(x+1), y)
+		if x+1 == v.w || (remaining[x+1] >= 0) != (r >= 0) {
+			svg.polyline(points, v.budgetColor(r), stroke)
+			points = []float64{float64(x + 1), y}
+		}
+	}
+
+	svg.annotations(annotationLayout(v.w, v.notes, v.tA, v.tτ, v.theme))
+
+	if v.labels {
+		labels, legend := v.axisLabels(ticks)
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the percentage of the error budget remaining at each
+// x-position, and the burn rate over the SLO's window which it reflects,
+// along with the counts of events recorded at each x-position by status.
+func (v *errorBudget) Data() *SeriesData {
+	remaining := v.remaining()
+	percent, burn := make([]float64, v.w), make([]float64, v.w)
+	for x, r := range remaining {
+		percent[x], burn[x] = 100*r, 1-r
+	}
+	return &SeriesData{
+		XAxis: TimeAxis,
+		X:     timeCoordinates(v.w, v.tA, v.tτ),
+		Series: map[string][]float64{
+			"budget-remaining": percent,
+			"burn-rate":        burn,
+			"success":          float64s(v.pass[v.k:]),
+			"failure":          float64s(v.fail[v.k:])}}
+}
+
+// Get the fraction of the error budget remaining at the end of each
+// x-position, over the SLO window ending there.
+func (v *errorBudget) remaining() []float64 {
+	r := make([]float64, v.w)
+	pass, fail := 0, 0
+	for i := range v.pass {
+		pass += v.pass[i]
+		fail += v.fail[i]
+		if i >= v.k {
+			pass -= v.pass[i-v.k]
+			fail -= v.fail[i-v.k]
+			r[i-v.k] = 1 - v.slo.burnRate(pass, fail)
+		}
+	}
+	return r
+}
+
+// Get the fraction of the budget remaining at the bottom of the axis: zero,
+// or the next quarter below the lowest point of the budget line if it was
+// overspent, down to no lower than -100%.
+func (v *errorBudget) floor(remaining []float64) float64 {
+	lo := 0.0
+	for _, r := range remaining {
+		lo = math.Min(lo, math.Floor(r*4)/4)
+	}
+	return math.Max(-1, lo)
+}
+
+// Get the y-position for the specified fraction of the budget remaining, on
+// an axis running from all of the budget at the top down to the specified
+// fraction at the bottom.
+func (v *errorBudget) y(r float64, lo float64) int {
+	r = math.Max(lo, math.Min(1, r))
+	return int(math.Round((1 - r) / (1 - lo) * float64(v.h-1)))
+}
+
+// Get the positions of the ticks on each quarter of the budget axis between
+// the bottom and the top.
+func (v *errorBudget) ticks(lo float64) []tick {
+	var ticks []tick
+	for r := lo + 0.25; r < 1; r += 0.25 {
+		ticks = append(ticks, tick{v.y(r, lo), 100 * r})
+	}
+	return ticks
+}
+
+// Get the color of the budget line at the specified fraction remaining.
+func (v *errorBudget) budgetColor(r float64) color.RGBA {
+	if r < 0 {
+		return v.theme.Failure
+	}
+	return v.theme.Success
+}
+
+// Get the labels for the time and budget axes, and the color legend.
+func (v *errorBudget) axisLabels(ticks []tick) ([]label, []legendEntry) {
+	labels := timeLabels(v.w, v.h, v.xGrid, v.tA, v.tτ)
+	for _, t := range ticks {
+		labels = append(
+			labels,
+			label{labelMargin, t.pos - glyphHeight - 1,
+				fmt.Sprintf("%.0f%%", t.value)})
+	}
+	legend := []legendEntry{
+		{v.theme.Success, "budget remaining"},
+		{v.theme.Failure, "budget overspent"}}
+	return labels, legend
+}
+
+// ParseDuration parses a length of time as a whole number of seconds, or of
+// minutes, hours, days, or weeks with an "m", "h", "d", or "w" suffix, such as
+// "90", "15m", or "30d". An "s" suffix for seconds is also accepted.
+func ParseDuration(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	unit := 1
+	for suffix, seconds := range map[string]int{
+		"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800} {
+
+		if strings.HasSuffix(s, suffix) {
+			s, unit = strings.TrimSuffix(s, suffix), seconds
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+// ParseDurations parses a comma-separated list of lengths of time, each in the
+// form accepted by ParseDuration, such as "1h,6h,1d,3d".
+func ParseDurations(s string) ([]int, error) {
+	var durations []int
+	for _, field := range strings.Split(s, ",") {
+		d, err := ParseDuration(field)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
@@ -0,0 +1,339 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PercentileEstimator is implemented by visualization generators which can
+// estimate percentiles of the run times of the events recorded into them.
+// Percentile takes a percentile in the range [0, 100] and returns the
+// estimated run time in seconds, or false if the data recorded cannot support
+// an estimate for that percentile.
+type PercentileEstimator interface {
+	Visualizer
+	Percentile(p float64) (float64, bool)
+}
+
+// Survival-curve visualization of event run times. In-progress events have not
+// yet finished, so all that is known of their eventual run times is that they
+// will be at least as long as they have been running so far. Rather than being
+// discarded (as they are by the histogram, which understates long run times as
+// a result) they are treated as right-censored observations, and the curves are
+// drawn from Kaplan-Meier estimates: the fraction of events still running at
+// each run time, and the cumulative fractions which have completed successfully
+// and which have failed, which together with the fraction still running always
+// sum to one.
+type survival struct {
+	w        int     // Width of the visualization
+	h        int     // Height of the visualization
+	theme    *Theme  // Colors to render the visualization with
+	xLog2    float64 // Number of pixels over which elapsed times double
+	tMin     float64 // Run time at the origin of the run-time axis
+	pass     []int   // Counts of successful events by x-axis position
+	fail     []int   // Counts of failed events by x-axis position
+	censored []int   // Counts of in-progress events by x-axis position
+	labels   bool    // Whether to draw axis labels and a legend
+	jitter   *Jitter // Source of random noise applied to run times
+}
+
+// Estimated curves of a survival visualization, giving the fractions of events
+// which had completed successfully, failed, or were still running at the end
+// of each x-position's run-time range.
+type survivalCurves struct {
+	pass    []float64 // Cumulative fraction of events completed successfully
+	fail    []float64 // Cumulative fraction of events failed
+	running []float64 // Fraction of events still running
+}
+
+// NewSurvival returns a run-time survival-curve-visualization generator, with
+// run times on a log2 scale along the x-axis. Run times past the right edge of
+// the visualization are counted at its last x-position, so they still weigh
+// into the curves.
+func NewSurvival(
+	width int,
+	height int,
+	theme *Theme,
+	xLog2 float64,
+	minRunTime float64,
+	labels bool,
+	jitter *Jitter) Visualizer {
+
+	return &survival{
+		width,
+		height,
+		themeOrDefault(theme),
+		xLog2,
+		minRunTime,
+		make([]int, width),
+		make([]int, width),
+		make([]int, width),
+		labels,
+		jitter}
+}
+
+// Record accepts an EventData pointer and plots it onto the visualization.
+func (v *survival) Record(e *EventData) {
+
+	// Apply a bit of random "noise" to the time scale, as is done for the
+	// histogram visualization, to avoid quantization artifacts in the run
+	// times of short-lived events.
+	t := e.RunTime() + v.jitter.offset(e)
+
+	x := intMin(int(v.xLog2*runTimeLog2(math.Max(v.tMin, t), v.tMin)), v.w-1)
+
+	if e.Status == 0 {
+		v.pass[x]++
+	} else if e.Status > 0 {
+		v.fail[x]++
+	} else {
+		v.censored[x]++
+	}
+}
+
+// Clone returns an empty survival-visualization generator with the same
+// configuration as this one.
+func (v *survival) Clone() Visualizer {
+	c := *v
+	c.pass = make([]int, v.w)
+	c.fail = make([]int, v.w)
+	c.censored = make([]int, v.w)
+	c.jitter = v.jitter.clone()
+	return &c
+}
+
+// Merge folds the data points recorded by a clone of this generator into it.
+func (v *survival) Merge(o Visualizer) {
+	other := o.(*survival)
+	addInts(v.pass, other.pass)
+	addInts(v.fail, other.fail)
+	addInts(v.censored, other.censored)
+}
+
+// Render returns the visualization constructed from all previously-recorded
+// data points.
+func (v *survival) Render() image.Image {
+
+	// Initialize our image canvas and grid.
+	vis := initializeVisualization(v.w, v.h, v.theme.Background)
+	for x := v.xLog2; x < float64(v.w); x += v.xLog2 {
+		drawXGridLine(vis, int(x), v.theme.Grid)
+	}
+	for _, t := range v.fractionTicks() {
+		drawYGridLine(vis, t.pos, v.theme.Grid)
+	}
+
+	// Draw each curve as a stepped line, rising or falling at each x-position
+	// from its level at the previous one.
+	stroke := intMax(1, v.h/96)
+	if curves := v.curves(); curves != nil {
+		for _, curve := range v.curveColors(curves) {
+			y0 := v.fractionY(curve.values[0])
+			for x, value := range curve.values {
+				y := v.fractionY(value)
+				yMin, yMax := intMin(y0, y)-stroke, intMax(y0, y)
+				for yPos := yMin; yPos <= yMax; yPos++ {
+					*getRGBA(vis, x, yPos) = curve.c
+				}
+				y0 = y
+			}
+		}
+	}
+
+	// Label the axes and draw a legend on top of everything else.
+	if v.labels {
+		labels, legend := v.axisLabels()
+		drawLabels(vis, labels, v.theme)
+		drawLegend(vis, legend, v.theme)
+	}
+
+	return vis
+}
+
+// RenderSVG writes the visualization constructed from all previously-recorded
+// data points out as an SVG document.
+func (v *survival) RenderSVG(out io.Writer) error {
+
+	svg := newSVGWriter(out, v.w, v.h, v.theme)
+	for x := v.xLog2; x < float64(v.w); x += v.xLog2 {
+		svg.xGridLine(int(x))
+	}
+	for _, t := range v.fractionTicks() {
+		svg.yGridLine(t.pos)
+	}
+
+	// Trace each curve as a stepped line.
+	if curves := v.curves(); curves != nil {
+		for _, curve := range v.curveColors(curves) {
+			points := []float64{0, float64(v.fractionY(curve.values[0]))}
+			for x, value := range curve.values {
+				y := float64(v.fractionY(value))
+				points = append(points, float64(x), y, float64(x+1), y)
+			}
+			svg.polyline(points, curve.c, float64(intMax(1, v.h/96)+1))
+		}
+	}
+
+	if v.labels {
+		labels, legend := v.axisLabels()
+		svg.labels(labels)
+		svg.legend(legend)
+	}
+
+	return svg.close()
+}
+
+// Data returns the estimated cumulative fractions of events completed
+// successfully and failed, and the estimated fraction of events still running,
+// at the end of each run-time bin, along with the counts of events recorded in
+// each bin by status.
+func (v *survival) Data() *SeriesData {
+	curves := v.curves()
+	if curves == nil {
+		curves = &survivalCurves{
+			make([]float64, v.w), make([]float64, v.w), make([]float64, v.w)}
+	}
+	return &SeriesData{
+		XAxis: RunTimeAxis,
+		X:     runTimeCoordinates(v.w, v.xLog2, v.tMin),
+		Series: map[string][]float64{
+			"success":           curves.pass,
+			"failure":           curves.fail,
+			"running":           curves.running,
+			"success-count":     float64s(v.pass),
+			"failure-count":     float64s(v.fail),
+			"in-progress-count": float64s(v.censored)}}
+}
+
+// Percentile returns the estimated run time in seconds by which the specified
+// percentage of events will have completed, whether successfully or not. The
+// estimate is made to the resolution of the visualization's x-positions, and
+// is given as the run time at the end of the x-position at which the estimated
+// fraction of completed events reaches the percentile. No estimate can be made
+// where the events still running at the longest run time recorded are enough
+// to leave the percentile unreached.
+func (v *survival) Percentile(p float64) (float64, bool) {
+	curves := v.curves()
+	if curves == nil {
+		return 0, false
+	}
+	for x, running := range curves.running {
+		if 1-running >= p/100-1e-9 {
+			return v.tMin * math.Exp2(float64(x+1)/v.xLog2), true
+		}
+	}
+	return 0, false
+}
+
+// Get the Kaplan-Meier estimates of the curves, or nil if no events have been
+// recorded. Completions at each x-position are taken to come before any
+// censoring at that position, as is the usual convention.
+func (v *survival) curves() *survivalCurves {
+	atRisk := 0
+	for x := 0; x < v.w; x++ {
+		atRisk += v.pass[x] + v.fail[x] + v.censored[x]
+	}
+	if atRisk == 0 {
+		return nil
+	}
+	c := &survivalCurves{
+		make([]float64, v.w), make([]float64, v.w), make([]float64, v.w)}
+	s, pass, fail := 1.0, 0.0, 0.0
+	for x := 0; x < v.w; x++ {
+		if atRisk > 0 {
+			n := float64(atRisk)
+			pass += s * float64(v.pass[x]) / n
+			fail += s * float64(v.fail[x]) / n
+			s *= 1 - float64(v.pass[x]+v.fail[x])/n
+		}
+		c.pass[x], c.fail[x], c.running[x] = pass, fail, s
+		atRisk -= v.pass[x] + v.fail[x] + v.censored[x]
+	}
+	return c
+}
+
+// A survival curve, paired with the color to draw it in.
+type coloredCurve struct {
+	values []float64  // Fraction of events at each x-position
+	c      color.RGBA // Color to draw the curve in
+}
+
+// Get the curves to draw, in the order they should be drawn.
+func (v *survival) curveColors(curves *survivalCurves) []coloredCurve {
+	return []coloredCurve{
+		{curves.running, v.theme.Active},
+		{curves.fail, v.theme.Failure},
+		{curves.pass, v.theme.Success}}
+}
+
+// Get the labels for the run-time and fraction axes, and the color legend.
+func (v *survival) axisLabels() ([]label, []legendEntry) {
+	legend := []legendEntry{
+		{v.theme.Success, "success"},
+		{v.theme.Failure, "failure"},
+		{v.theme.Active, "running"}}
+	labels := runTimeLabelsX(v.w, v.h, v.xLog2, v.tMin)
+	labels = labelsLeftOf(labels, legendLeft(v.w, legend))
+	for _, t := range v.fractionTicks() {
+		labels = append(
+			labels,
+			label{labelMargin, t.pos - glyphHeight - 1,
+				fmt.Sprintf("%.0f%%", t.value)})
+	}
+	return labels, legend
+}
+
+// Get the positions of the ticks on each quarter of the fraction axis.
+func (v *survival) fractionTicks() []tick {
+	var ticks []tick
+	for p := 25; p < 100; p += 25 {
+		ticks = append(ticks, tick{v.fractionY(float64(p) / 100), float64(p)})
+	}
+	return ticks
+}
+
+// Get the y-position for the specified fraction of events. Positions are inset
+// from the top and bottom edges far enough for the curves to remain visible at
+// zero and at one.
+func (v *survival) fractionY(f float64) int {
+	return 2 + int(math.Round(float64(v.h-5)*(1-f)))
+}
+
+// ParsePercentiles parses a comma-separated list of percentiles in the range
+// [0, 100], such as "50,90,99.9".
+func ParsePercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		if p < 0 || p > 100 {
+			return nil, fmt.Errorf("percentile out of range: %g", p)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
@@ -0,0 +1,280 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// Minimal SVG document writer used by the vector renderers. Write errors are
+// sticky, so a renderer can issue all of its drawing calls and check for an
+// error once when the document is closed.
+type svgWriter struct {
+	out   io.Writer // Destination for the SVG document
+	w     int       // Width of the visualization
+	h     int       // Height of the visualization
+	theme *Theme    // Colors to render the visualization with
+	err   error     // First error encountered while writing, if any
+}
+
+// Utility function for setting up an SVG document of the specified size, with
+// its background filled in with the background color of the specified theme.
+func newSVGWriter(out io.Writer, width int, height int, t *Theme) *svgWriter {
+	s := &svgWriter{out: out, w: width, h: height, theme: t}
+	s.printf(
+		"<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" "+
+			"width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		width, height, width, height)
+	s.rect(0, 0, float64(width), float64(height), t.Background)
+	return s
+}
+
+func (s *svgWriter) printf(format string, args ...interface{}) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.out, format, args...)
+	}
+}
+
+// Close terminates the SVG document and returns the first error encountered
+// while writing it, if any.
+func (s *svgWriter) close() error {
+	s.printf("</svg>\n")
+	return s.err
+}
+
+func (s *svgWriter) rect(
+	x float64,
+	y float64,
+	w float64,
+	h float64,
+	c color.RGBA) {
+
+	if w <= 0 || h <= 0 {
+		return
+	}
+	s.printf(
+		"<rect x=\"%g\" y=\"%g\" width=\"%g\" height=\"%g\" fill=\"%s\"/>\n",
+		x, y, w, h, svgColor(c))
+}
+
+func (s *svgWriter) line(
+	x1 float64,
+	y1 float64,
+	x2 float64,
+	y2 float64,
+	c color.RGBA,
+	stroke float64,
+	dashed bool) {
+
+	dash := ""
+	if dashed {
+		dash = " stroke-dasharray=\"1,3\""
+	}
+	s.printf(
+		"<line x1=\"%g\" y1=\"%g\" x2=\"%g\" y2=\"%g\" stroke=\"%s\" "+
+			"stroke-width=\"%g\"%s/>\n",
+		x1, y1, x2, y2, svgColor(c), stroke, dash)
+}
+
+// Draw an open line through the given points, which are specified as
+// alternating x and y coordinates.
+func (s *svgWriter) polyline(points []float64, c color.RGBA, stroke float64) {
+	if len(points) < 4 {
+		return
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(points); i += 2 {
+		fmt.Fprintf(&b, "%g,%g ", points[i], points[i+1])
+	}
+	s.printf(
+		"<polyline points=\"%s\" fill=\"none\" stroke=\"%s\" "+
+			"stroke-width=\"%g\" stroke-linejoin=\"round\"/>\n",
+		strings.TrimSpace(b.String()), svgColor(c), stroke)
+}
+
+// Draw a filled polygon through the given points, which are specified as
+// alternating x and y coordinates.
+func (s *svgWriter) polygon(points []float64, c color.RGBA) {
+	if len(points) < 6 {
+		return
+	}
+	var b strings.Builder
+	for i := 0; i+1 < len(points); i += 2 {
+		fmt.Fprintf(&b, "%g,%g ", points[i], points[i+1])
+	}
+	s.printf(
+		"<polygon points=\"%s\" fill=\"%s\"/>\n",
+		strings.TrimSpace(b.String()), svgColor(c))
+}
+
+// Vector equivalent of drawStackedArea, tracing each layer of the stack as a
+// polygon running along the top of the layer and then back along the top of
+// the layer beneath it.
+func (s *svgWriter) stackedArea(
+	layers [][]float64,
+	colors []color.RGBA,
+	scale float64) {
+
+	base := make([]float64, s.w)
+	for layer, values := range layers {
+		var points []float64
+		top := make([]float64, s.w)
+		for x := 0; x < s.w; x++ {
+			top[x] = base[x] + values[x]*scale
+			y := float64(s.h) - math.Ceil(top[x])
+			points = append(points, float64(x), y, float64(x+1), y)
+		}
+		for x := s.w - 1; x >= 0; x-- {
+			y := float64(s.h) - math.Ceil(base[x])
+			points = append(points, float64(x+1), y, float64(x), y)
+		}
+		s.polygon(points, colors[layer])
+		base = top
+	}
+}
+
+// Vector equivalent of drawXGridLine.
+func (s *svgWriter) xGridLine(x int) {
+	c := s.theme.Grid
+	s.line(float64(x)+0.5, 0, float64(x)+0.5, float64(s.h), c, 1, false)
+}
+
+// Vector equivalent of drawYGridLine.
+func (s *svgWriter) yGridLine(y int) {
+	c := s.theme.Grid
+	s.line(0, float64(y)+0.5, float64(s.w), float64(y)+0.5, c, 1, false)
+}
+
+// Vector equivalent of drawPolarGridRadialTicks.
+func (s *svgWriter) polarGridRadialTicks(r float64) {
+	x0, y0 := float64(s.w/2), float64(s.h/2)
+	tickScale := float64(int(float64(intMin(s.w, s.h)) / 72))
+	c := s.theme.Grid
+	for _, y := range []float64{y0 - r, y0 + r - 1} {
+		s.line(x0-tickScale, y+0.5, x0+tickScale+1, y+0.5, c, 1, false)
+	}
+	for _, x := range []float64{x0 - r, x0 + r - 1} {
+		s.line(x+0.5, y0-tickScale, x+0.5, y0+tickScale+1, c, 1, false)
+	}
+}
+
+// Vector equivalent of drawText. Text is set in the viewer's monospace font at
+// roughly the size of the embedded bitmap font, rather than being traced out
+// glyph by glyph, and is haloed with a stroke in the background color.
+func (s *svgWriter) text(x int, y int, text string, c color.RGBA) {
+	s.printf(
+		"<text x=\"%d\" y=\"%d\" fill=\"%s\" stroke=\"%s\" "+
+			"stroke-width=\"2\" paint-order=\"stroke\" "+
+			"font-family=\"monospace\" font-size=\"%d\">%s</text>\n",
+		x, y+glyphHeight, svgColor(c), svgColor(s.theme.Background),
+		glyphHeight+2,
+		html.EscapeString(text))
+}
+
+// Vector equivalent of drawLabels.
+func (s *svgWriter) labels(labels []label) {
+	for _, l := range labels {
+		s.text(l.x, l.y, l.text, s.theme.Text)
+	}
+}
+
+// Vector equivalent of drawLegend.
+func (s *svgWriter) legend(entries []legendEntry) {
+	swatches, labels := legendLayout(s.w, entries)
+	for i, r := range swatches {
+		s.rect(
+			float64(r.Min.X), float64(r.Min.Y),
+			float64(r.Dx()), float64(r.Dy()),
+			entries[i].c)
+	}
+	s.labels(labels)
+}
+
+// Vector equivalent of drawAnnotations.
+func (s *svgWriter) annotations(marks []annotationMark) {
+	for _, m := range marks {
+		if m.span {
+			shade := m.c
+			shade.A = clampChannel(spanOpacity * opaque)
+			s.rect(
+				float64(m.x0+1), 0, float64(m.x1-m.x0-1), float64(s.h), shade)
+			s.line(
+				float64(m.x1)+0.5, 0, float64(m.x1)+0.5, float64(s.h),
+				m.c, 1, false)
+		}
+		s.line(
+			float64(m.x0)+0.5, 0, float64(m.x0)+0.5, float64(s.h),
+			m.c, 1, false)
+	}
+	for _, m := range marks {
+		if m.label != nil {
+			s.text(m.label.x, m.label.y, m.label.text, m.c)
+		}
+	}
+}
+
+// Emit the plotted pixels of a density-style rendering (everything which
+// differs from the background color) as an embedded PNG image, with the
+// background left transparent so the vector grid shows through. This is used
+// for the scatter visualizations, whose bloom-blurred point clouds have no
+// more natural vector representation, so they stay raster (drawn with hard
+// pixel edges when scaled) while their grids, labels, and legends are vector.
+func (s *svgWriter) pixels(vis *image.RGBA) {
+	bg := s.theme.Background
+	b := vis.Bounds()
+	layer := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := *getRGBA(vis, x, y); c != bg {
+				layer.SetRGBA(x, y, c)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, layer); err != nil {
+		if s.err == nil {
+			s.err = err
+		}
+		return
+	}
+	s.printf(
+		"<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" "+
+			"style=\"image-rendering:pixelated\" "+
+			"xmlns:xlink=\"http://www.w3.org/1999/xlink\" "+
+			"xlink:href=\"data:image/png;base64,%s\"/>\n",
+		b.Min.X, b.Min.Y, b.Dx(), b.Dy(),
+		base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// Utility function to format a color as an SVG paint value.
+func svgColor(c color.RGBA) string {
+	if c.A != opaque {
+		return fmt.Sprintf(
+			"rgba(%d,%d,%d,%.3f)", c.R, c.G, c.B, float64(c.A)/opaque)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,130 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// Height of a terminal character cell, in pixels of the visualization being
+// rendered. Each cell of a sparkline is filled in eighths by the Unicode block
+// elements, so a column of cells resolves the same heights as a column of
+// pixels in a raster rendering.
+const cellHeight = 8
+
+// Unicode block elements for each eighth of the fill of a character cell.
+var blocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// ANSI escape sequence to reset the terminal's text color.
+const ansiReset = "\x1b[0m"
+
+// Minimal terminal text writer used by the text renderers, which draws
+// sparklines out of Unicode block elements colored with 24-bit ANSI escape
+// sequences. As with svgWriter, write errors are sticky.
+type textWriter struct {
+	out io.Writer // Destination for the text
+	w   int       // Width of the visualization, in characters
+	err error     // First error encountered while writing, if any
+}
+
+func newTextWriter(out io.Writer, width int) *textWriter {
+	return &textWriter{out: out, w: width}
+}
+
+func (t *textWriter) printf(format string, args ...interface{}) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.out, format, args...)
+	}
+}
+
+// Draw a sparkline of the specified number of rows, with the height of each
+// column scaled by the specified factor from pixels to eighths of a cell and
+// colored as the specified function gives for its x-position. The text of each
+// row is only colored where its color changes, to keep the output compact.
+func (t *textWriter) sparkline(
+	values []float64,
+	scale float64,
+	rows int,
+	colorAt func(x int) color.RGBA) {
+
+	for row := rows - 1; row >= 0; row-- {
+		var last *color.RGBA
+		for x := 0; x < t.w; x++ {
+			fill := 0
+			if level := values[x] * scale; level > 0 && !math.IsInf(level, 1) {
+				fill = intMin(int(math.Ceil(level))-row*cellHeight, cellHeight)
+				fill = intMax(0, fill)
+			}
+			if c := colorAt(x); fill > 0 && (last == nil || c != *last) {
+				t.printf("%s", ansiColor(c))
+				last = &c
+			}
+			t.printf("%c", blocks[fill])
+		}
+		t.printf("%s\n", ansiReset)
+	}
+}
+
+// Draw a line of axis labels, placing the text of each label at the column of
+// its x-position, and dropping any label which would overlap the one before it.
+func (t *textWriter) axis(labels []label) {
+	line := []rune(strings.Repeat(" ", t.w))
+	end := 0
+	for _, l := range labels {
+		x := l.x - labelMargin
+		text := []rune(l.text)
+		if x < end || x+len(text) > t.w {
+			continue
+		}
+		copy(line[x:], text)
+		end = x + len(text) + 1
+	}
+	t.printf("%s\n", strings.TrimRight(string(line), " "))
+}
+
+// Draw a color legend on a single line.
+func (t *textWriter) legend(entries []legendEntry) {
+	for i, e := range entries {
+		if i > 0 {
+			t.printf("  ")
+		}
+		t.printf(
+			"%s%c%s %s", ansiColor(e.c), blocks[cellHeight], ansiReset, e.text)
+	}
+	t.printf("\n")
+}
+
+// Close returns the first error encountered while writing the text, if any.
+func (t *textWriter) close() error {
+	return t.err
+}
+
+// Utility function to format an ANSI escape sequence setting the text color.
+func ansiColor(c color.RGBA) string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+}
+
+// Utility function to get the labels for a time axis drawn as text, with a
+// label roughly every sixteen characters.
+func textTimeLabels(w int, tA float64, tτ float64) []label {
+	return timeLabels(w, 0, intMax(1, w/16), tA, tτ)
+}
@@ -0,0 +1,449 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+	"unicode"
+)
+
+const (
+	glyphWidth   = 5 // Width of a glyph in the embedded font, in pixels
+	glyphHeight  = 7 // Height of a glyph in the embedded font, in pixels
+	glyphAdvance = 6 // Horizontal distance from one glyph to the next
+	labelMargin  = 2 // Distance of labels from the edges of the image
+)
+
+// Embedded 5x7 bitmap font, with each glyph given as a list of rows from top to
+// bottom, and the five least significant bits of each row representing its
+// pixels from left to right. Only upper-case letters are provided, and text is
+// converted to upper case when drawn. Characters which have no glyph here are
+// drawn as blank space.
+var glyphs = map[rune][glyphHeight]uint8{
+	'0':  {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1':  {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2':  {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3':  {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4':  {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5':  {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6':  {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9':  {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	'A':  {0x0e, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'B':  {0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e},
+	'C':  {0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e},
+	'D':  {0x1c, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1c},
+	'E':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f},
+	'F':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10},
+	'G':  {0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f},
+	'H':  {0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'I':  {0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f},
+	'M':  {0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'P':  {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'Q':  {0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d},
+	'R':  {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'S':  {0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e},
+	'T':  {0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a},
+	'X':  {0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x0a, 0x04, 0x04, 0x04, 0x04},
+	'Z':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f},
+	':':  {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'-':  {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'<':  {0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02},
+	'>':  {0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08},
+	'+':  {0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f},
+	'\'': {0x04, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0c, 0x04, 0x08},
+	'=':  {0x00, 0x00, 0x1f, 0x00, 0x1f, 0x00, 0x00},
+	'#':  {0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a},
+}
+
+// A piece of text to be drawn onto a visualization, positioned by the top-left
+// corner of its first glyph.
+type label struct {
+	x    int
+	y    int
+	text string
+}
+
+// An entry in the color legend of a visualization.
+type legendEntry struct {
+	c    color.RGBA
+	text string
+}
+
+// Utility function to draw text onto a visualization using the embedded font.
+// The text is given a one-pixel halo in the specified background color so that
+// it remains legible where it overlaps plotted data.
+func drawText(
+	vis *image.RGBA,
+	x int,
+	y int,
+	text string,
+	c color.RGBA,
+	bg color.RGBA) {
+
+	for _, pass := range []struct {
+		c      color.RGBA
+		offset []image.Point
+	}{
+		{bg, []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}},
+		{c, []image.Point{{0, 0}}},
+	} {
+		x0 := x
+		for _, r := range text {
+			rows := glyphs[unicode.ToUpper(r)]
+			for gy := 0; gy < glyphHeight; gy++ {
+				for gx := 0; gx < glyphWidth; gx++ {
+					if rows[gy]&(1<<uint(glyphWidth-1-gx)) == 0 {
+						continue
+					}
+					for _, o := range pass.offset {
+						*getRGBA(vis, x0+gx+o.X, y+gy+o.Y) = pass.c
+					}
+				}
+			}
+			x0 += glyphAdvance
+		}
+	}
+}
+
+// Utility function to draw a set of labels onto a visualization.
+func drawLabels(vis *image.RGBA, labels []label, t *Theme) {
+	for _, l := range labels {
+		drawText(vis, l.x, l.y, l.text, t.Text, t.Background)
+	}
+}
+
+// Utility function to draw a color legend into the top-right corner of a
+// visualization.
+func drawLegend(vis *image.RGBA, entries []legendEntry, t *Theme) {
+	swatches, labels := legendLayout(vis.Bounds().Max.X, entries)
+	for i, s := range swatches {
+		for y := s.Min.Y; y < s.Max.Y; y++ {
+			for x := s.Min.X; x < s.Max.X; x++ {
+				*getRGBA(vis, x, y) = entries[i].c
+			}
+		}
+	}
+	drawLabels(vis, labels, t)
+}
+
+// Lay out a color legend as a column of swatches with a label beside each one,
+// aligned to the top-right corner of a visualization of the specified width.
+func legendLayout(w int, entries []legendEntry) ([]image.Rectangle, []label) {
+	maxLen := 0
+	for _, e := range entries {
+		if n := len([]rune(e.text)); n > maxLen {
+			maxLen = n
+		}
+	}
+	x := w - labelMargin - maxLen*glyphAdvance - glyphAdvance - glyphHeight
+	swatches := make([]image.Rectangle, len(entries))
+	labels := make([]label, len(entries))
+	for i, e := range entries {
+		y := labelMargin + i*(glyphHeight+3)
+		swatches[i] = image.Rect(x, y, x+glyphHeight, y+glyphHeight)
+		labels[i] = label{x + glyphHeight + glyphAdvance, y, e.text}
+	}
+	return swatches, labels
+}
+
+// Get the left edge of the color legend for a visualization of the specified
+// width, so labels can be kept from running underneath it.
+func legendLeft(w int, entries []legendEntry) int {
+	swatches, _ := legendLayout(w, entries)
+	if len(swatches) == 0 {
+		return w
+	}
+	return swatches[0].Min.X - labelMargin
+}
+
+// Utility function to drop any labels which would extend past the specified
+// x-position.
+func labelsLeftOf(labels []label, x int) []label {
+	var kept []label
+	for _, l := range labels {
+		if l.x+len([]rune(l.text))*glyphAdvance < x {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// Round intervals at which a time axis without grid divisions may be labeled,
+// from the shortest to the longest, each given as a number of seconds or of
+// calendar months.
+var timeTickSteps = []struct {
+	seconds int64
+	months  int
+}{
+	{60, 0},
+	{5 * 60, 0},
+	{15 * 60, 0},
+	{30 * 60, 0},
+	{3600, 0},
+	{3 * 3600, 0},
+	{6 * 3600, 0},
+	{12 * 3600, 0},
+	{86400, 0},
+	{7 * 86400, 0},
+	{0, 1},
+	{0, 3},
+	{0, 12},
+	{0, 120}}
+
+// Get the labels for the start times of each of the specified number of
+// divisions of a time axis, drawn along the bottom edge of the visualization.
+// If the axis has no divisions, it is instead labeled at round times spaced
+// far enough apart for the labels not to run into one another.
+func timeLabels(w int, h int, xGrid int, tA float64, tτ float64) []label {
+	if xGrid <= 0 {
+		return timeTickLabels(w, h, tA, tτ)
+	}
+
+	// Pick a time format appropriate to the size of a grid division, so labels
+	// stay short enough to fit.
+	step := tτ / float64(xGrid)
+	format := timeLabelFormat(step)
+
+	var labels []label
+	for i := 0; i < xGrid; i++ {
+		t := time.Unix(int64(tA+float64(i)*step), 0).UTC()
+		labels = append(
+			labels,
+			label{i*w/xGrid + labelMargin, h - labelMargin - glyphHeight,
+				t.Format(format)})
+	}
+	return labels
+}
+
+// Get the labels for a time axis without grid divisions, at the round times
+// of the shortest interval whose labels can be told apart at the scale of the
+// axis. Labels which would run off the right edge are left out.
+func timeTickLabels(w int, h int, tA float64, tτ float64) []label {
+	if w <= 0 || tτ <= 0 {
+		return nil
+	}
+	for _, step := range timeTickSteps {
+		seconds := float64(step.seconds + int64(step.months)*30*86400)
+		format := timeLabelFormat(seconds)
+		if seconds*float64(w)/tτ < float64((len(format)+2)*glyphAdvance) {
+			continue
+		}
+
+		// Round the start of the axis down to a time at the interval, counting
+		// from the start of the Unix epoch or of year zero, and then step up to
+		// the first such time on the axis.
+		t := time.Unix(int64(tA), 0).UTC()
+		if step.months > 0 {
+			m := 12*t.Year() + int(t.Month()) - 1
+			m -= m % step.months
+			t = time.Date(m/12, time.Month(m%12+1), 1, 0, 0, 0, 0, time.UTC)
+		} else {
+			t = time.Unix(t.Unix()/step.seconds*step.seconds, 0).UTC()
+		}
+		for float64(t.Unix()) < tA {
+			t = nextTimeTick(t, step.seconds, step.months)
+		}
+
+		var labels []label
+		for float64(t.Unix()) < tA+tτ {
+			x := int(float64(w)*(float64(t.Unix())-tA)/tτ) + labelMargin
+			if x+len(format)*glyphAdvance > w {
+				break
+			}
+			labels = append(
+				labels,
+				label{x, h - labelMargin - glyphHeight, t.Format(format)})
+			t = nextTimeTick(t, step.seconds, step.months)
+		}
+		return labels
+	}
+	return nil
+}
+
+// Get the time of the next tick after the specified one on a time axis labeled
+// at the specified interval.
+func nextTimeTick(t time.Time, seconds int64, months int) time.Time {
+	if months > 0 {
+		return t.AddDate(0, months, 0)
+	}
+	return t.Add(time.Duration(seconds) * time.Second)
+}
+
+// Get a time format appropriate to labels spaced the specified number of
+// seconds apart, so labels stay short enough to fit.
+func timeLabelFormat(step float64) string {
+	if step >= 365*86400 {
+		return "2006"
+	} else if step >= 28*86400 {
+		return "2006-01"
+	} else if step >= 86400 {
+		return "01-02"
+	}
+	return "15:04"
+}
+
+// Get the labels for the run-time doublings of a log2 axis running upward from
+// the bottom edge of the visualization, as drawn by the horizontal grid lines,
+// with the specified run time at its origin.
+func runTimeLabels(h int, yLog2 float64, tMin float64) []label {
+	var labels []label
+	every := logLabelInterval(yLog2)
+	for i, y := 0, float64(h); y > glyphHeight; i, y = i+1, y-yLog2 {
+		if i > 0 && i%every == 0 {
+			labels = append(
+				labels,
+				label{labelMargin, int(y) - glyphHeight - 1,
+					runTimeLabel(i, tMin)})
+		}
+	}
+	return labels
+}
+
+// Get the labels for the run-time doublings of a log2 axis running rightward
+// from the left edge of the visualization, as used by the histogram, with the
+// specified run time at its origin.
+func runTimeLabelsX(w int, h int, yLog2 float64, tMin float64) []label {
+	var labels []label
+	every := logLabelInterval(yLog2 / 3)
+	for i, x := 1, yLog2; x < float64(w); i, x = i+1, x+yLog2 {
+		if i%every == 0 {
+			labels = append(
+				labels,
+				label{int(x) + labelMargin, labelMargin, runTimeLabel(i, tMin)})
+		}
+	}
+	return labels
+}
+
+// Get the labels for the run-time doublings of a polar visualization, as drawn
+// by the radial tick marks along its upward-pointing axis, with the specified
+// run time at its center.
+func polarRunTimeLabels(
+	w int,
+	h int,
+	yLog2 float64,
+	tMin float64) []label {
+
+	var labels []label
+	x0, y0 := w/2, h/2
+	every := logLabelInterval(yLog2)
+	for i, r := 1, yLog2; int(r) < y0; i, r = i+1, r+yLog2 {
+		if i%every == 0 {
+			labels = append(
+				labels,
+				label{x0 + labelMargin + 1, y0 - int(r) - glyphHeight - 1,
+					runTimeLabel(i, tMin)})
+		}
+	}
+	return labels
+}
+
+// A grid position along a linear value axis, with the value it represents.
+type tick struct {
+	pos   int
+	value float64
+}
+
+// Get evenly-spaced ticks for a linear count axis running upward from the
+// bottom edge of a visualization of the specified height, where values are
+// multiplied by the specified scale to get their heights in pixels. Ticks fall
+// on round values (one, two, or five times a power of ten), with no more than
+// one tick for every 32 pixels of height.
+func countTicks(h int, scale float64) []tick {
+	if scale <= 0 || math.IsInf(scale, 0) {
+		return nil
+	}
+	maxValue := float64(h) / scale
+	step := math.Pow(10, math.Floor(math.Log10(maxValue)))
+	for _, m := range []float64{0.1, 0.2, 0.5, 1} {
+		if maxValue/(step*m) <= math.Max(1, float64(h/32)) {
+			step *= m
+			break
+		}
+	}
+	step = math.Max(1, step)
+	var ticks []tick
+	for value := step; value*scale < float64(h); value += step {
+		ticks = append(ticks, tick{h - int(value*scale), value})
+	}
+	return ticks
+}
+
+// Get the labels for a set of ticks along a linear count axis.
+func countLabels(ticks []tick) []label {
+	var labels []label
+	for _, t := range ticks {
+		labels = append(
+			labels,
+			label{labelMargin, t.pos - glyphHeight - 1,
+				fmt.Sprintf("%.0f", t.value)})
+	}
+	return labels
+}
+
+// Get the interval at which doublings on a log2 axis should be labeled to keep
+// the labels from overlapping one another.
+func logLabelInterval(pixelsPerDoubling float64) int {
+	return int(math.Max(1, math.Ceil((glyphHeight+3)/pixelsPerDoubling)))
+}
+
+// Format the run time at the specified number of doublings from the specified
+// run time at the origin of an axis, rounded to the largest unit of time it
+// represents (e.g. "4s", "17m", "9h").
+func runTimeLabel(doublings int, tMin float64) string {
+	return formatRunTime(tMin * math.Exp2(float64(doublings)))
+}
+
+// Format a run time given in seconds using a compact, human-friendly unit.
+func formatRunTime(t float64) string {
+	switch {
+	case t < 0.001:
+		return fmt.Sprintf("%.0fus", t*1000000)
+	case t < 1:
+		return fmt.Sprintf("%.0fms", t*1000)
+	case t < 60:
+		return fmt.Sprintf("%.0fs", t)
+	case t < 3600:
+		return fmt.Sprintf("%.0fm", t/60)
+	case t < 86400:
+		return fmt.Sprintf("%.0fh", t/3600)
+	}
+	return fmt.Sprintf("%.0fd", t/86400)
+}
@@ -0,0 +1,311 @@
+// Perspective: Graphing library for quality control in event-driven systems
+
+// Copyright (C) 2015 Christian Paro <christian.paro@gmail.com>,
+//                                   <cparo@digitalocean.com>
+
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU General Public License version 2 as published by the
+// Free Software Foundation.
+
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+// FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+
+// You should have received a copy of the GNU General Public License along with
+// this program. If not, see <http://www.gnu.org/licenses/>.
+
+package perspective
+
+import (
+	"image/color"
+	"math"
+)
+
+// Theme is the set of colors used to render visualizations. The success,
+// failure, and active colors are used as-is for solid fills, and as the hue of
+// the glow for density-style visualizations, which brighten toward these
+// colors on dark backgrounds and darken toward them on light backgrounds. The
+// error ramp gives the colors for the first and last of a set of stacked error
+// classes, with the colors between them interpolated. The error hues are
+// distinct colors for telling error classes apart in density-style
+// visualizations, where shades of a single color would blur together; they
+// are reused in turn when there are more classes than hues. A theme may also
+// set out its inks explicitly, rather than having them derived from its
+// colors.
+type Theme struct {
+	Background color.RGBA    // Visualization background
+	Grid       color.RGBA    // Grid lines and tick marks
+	Text       color.RGBA    // Axis labels and legend text
+	Success    color.RGBA    // Successful events
+	Failure    color.RGBA    // Failed events
+	Active     color.RGBA    // Active (in-progress) events
+	ErrorRamp  [2]color.RGBA // Range of colors for stacked error classes
+	ErrorHues  []color.RGBA  // Distinct colors for plotted error classes
+	Inks       *Inks         // Explicit inks, or nil to derive them
+}
+
+// Inks are the amounts by which plotted data moves a pixel's red, green, and
+// blue channels, for each status of event in the order success, failure,
+// active. Density inks are given for a full step of color in density-style
+// visualizations, and the count-line and run-time-line inks for each stroke of
+// those lines.
+type Inks struct {
+	Density [3][3]float64 // Data points in density-style visualizations
+	Counts  [2][3]float64 // Strokes of the success and failure count lines
+	Lines   [3][3]float64 // Strokes of the run-time line
+	Hatch   [3]float64    // Hatching where the count lines drop off
+}
+
+// Built-in themes:
+var (
+	// DarkTheme is the default theme, with a dark gray background. Its inks
+	// are those of the original renderers, from before themes were added.
+	DarkTheme = &Theme{
+		Background: color.RGBA{32, 32, 32, opaque},
+		Grid:       color.RGBA{50, 50, 50, opaque},
+		Text:       color.RGBA{150, 150, 150, opaque},
+		Success:    color.RGBA{83, 83, 191, opaque},
+		Failure:    color.RGBA{191, 33, 33, opaque},
+		Active:     color.RGBA{33, 151, 33, opaque},
+		ErrorRamp: [2]color.RGBA{
+			{127, 11, 11, opaque},
+			{254, 181, 181, opaque}},
+		ErrorHues: []color.RGBA{
+			{191, 33, 33, opaque},
+			{221, 125, 24, opaque},
+			{205, 195, 45, opaque},
+			{195, 55, 175, opaque},
+			{135, 80, 215, opaque},
+			{170, 170, 170, opaque}},
+		Inks: &Inks{
+			Density: [3][3]float64{
+				{63.75, 63.75, 255},
+				{255, 0, 0},
+				{0, 255, 0}},
+			Counts: [2][3]float64{
+				{24, 24, 128},
+				{128, 24, 24}},
+			Lines: [3][3]float64{
+				{32, 32, 160},
+				{160, 32, 32},
+				{32, 160, 32}},
+			Hatch: [3]float64{18, 18, 18}}}
+
+	// LightTheme has a white background, for printing.
+	LightTheme = &Theme{
+		Background: color.RGBA{255, 255, 255, opaque},
+		Grid:       color.RGBA{221, 221, 221, opaque},
+		Text:       color.RGBA{68, 68, 68, opaque},
+		Success:    color.RGBA{40, 70, 190, opaque},
+		Failure:    color.RGBA{200, 30, 30, opaque},
+		Active:     color.RGBA{20, 140, 40, opaque},
+		ErrorRamp: [2]color.RGBA{
+			{110, 0, 0, opaque},
+			{250, 160, 150, opaque}},
+		ErrorHues: []color.RGBA{
+			{200, 30, 30, opaque},
+			{225, 115, 0, opaque},
+			{175, 150, 0, opaque},
+			{180, 30, 160, opaque},
+			{110, 50, 190, opaque},
+			{90, 90, 90, opaque}}}
+
+	// ColorblindTheme has a dark background, with colors from the Okabe-Ito
+	// palette which remain distinguishable with the common forms of color
+	// vision deficiency. Success and failure are set apart as blue and orange
+	// rather than as blue (or green) and red.
+	ColorblindTheme = &Theme{
+		Background: color.RGBA{32, 32, 32, opaque},
+		Grid:       color.RGBA{54, 54, 54, opaque},
+		Text:       color.RGBA{160, 160, 160, opaque},
+		Success:    color.RGBA{86, 180, 233, opaque},
+		Failure:    color.RGBA{230, 159, 0, opaque},
+		Active:     color.RGBA{204, 121, 167, opaque},
+		ErrorRamp: [2]color.RGBA{
+			{140, 70, 0, opaque},
+			{250, 230, 120, opaque}},
+		ErrorHues: []color.RGBA{
+			{230, 159, 0, opaque},
+			{213, 94, 0, opaque},
+			{240, 228, 66, opaque},
+			{0, 158, 115, opaque},
+			{200, 200, 200, opaque}}}
+
+	// ColorblindLightTheme is the light-background, printable counterpart to
+	// ColorblindTheme.
+	ColorblindLightTheme = &Theme{
+		Background: color.RGBA{255, 255, 255, opaque},
+		Grid:       color.RGBA{221, 221, 221, opaque},
+		Text:       color.RGBA{68, 68, 68, opaque},
+		Success:    color.RGBA{0, 114, 178, opaque},
+		Failure:    color.RGBA{213, 94, 0, opaque},
+		Active:     color.RGBA{204, 121, 167, opaque},
+		ErrorRamp: [2]color.RGBA{
+			{120, 50, 0, opaque},
+			{240, 200, 80, opaque}},
+		ErrorHues: []color.RGBA{
+			{213, 94, 0, opaque},
+			{230, 159, 0, opaque},
+			{190, 170, 0, opaque},
+			{0, 158, 115, opaque},
+			{80, 80, 80, opaque}}}
+)
+
+// Themes maps names to the built-in themes, for selection by end users.
+var Themes = map[string]*Theme{
+	"dark":             DarkTheme,
+	"light":            LightTheme,
+	"colorblind":       ColorblindTheme,
+	"colorblind-light": ColorblindLightTheme,
+}
+
+// WithBackground returns a copy of the theme using the specified gray level
+// for its background.
+func (t *Theme) WithBackground(level int) *Theme {
+	c := *t
+	c.Background = grayRGBA(level)
+	return &c
+}
+
+// Utility function to get the theme for a visualization generator, falling
+// back to the default theme if none was specified.
+func themeOrDefault(t *Theme) *Theme {
+	if t == nil {
+		return DarkTheme
+	}
+	return t
+}
+
+// Whether the theme has a light background, which plotted data should darken
+// rather than brighten.
+func (t *Theme) light() bool {
+	b := t.Background
+	return int(b.R)+int(b.G)+int(b.B) > 3*128
+}
+
+// Get the "ink" for a color in this theme: the direction in which plotting
+// data of that color should move a pixel's color channels, with the largest
+// channel scaled to a full step of 255. On dark backgrounds this is the color
+// itself, brightened; on light backgrounds it is the negative of the color's
+// distance from white, so that overlapping data darkens as it accumulates.
+func (t *Theme) ink(c color.RGBA) [3]float64 {
+	base := 0.0
+	if t.light() {
+		base = saturated
+	}
+	ink := [3]float64{
+		float64(c.R) - base,
+		float64(c.G) - base,
+		float64(c.B) - base}
+	m := math.Max(
+		math.Abs(ink[0]), math.Max(math.Abs(ink[1]), math.Abs(ink[2])))
+	if m > 0 {
+		for i := range ink {
+			ink[i] *= saturated / m
+		}
+	}
+	return ink
+}
+
+// Get the inks for the successful, failed, and active events of density-style
+// visualizations.
+func (t *Theme) densityInks() [3][3]float64 {
+	if t.Inks != nil {
+		return t.Inks.Density
+	}
+	return [3][3]float64{t.ink(t.Success), t.ink(t.Failure), t.ink(t.Active)}
+}
+
+// Get the inks for each stroke of the success and failure count lines.
+func (t *Theme) countInks() [2][3]float64 {
+	if t.Inks != nil {
+		return t.Inks.Counts
+	}
+	return [2][3]float64{
+		scaledInk(t.ink(t.Success), countInk),
+		scaledInk(t.ink(t.Failure), countInk)}
+}
+
+// Get the inks for each stroke of the run-time line where all of its events
+// are successful, failed, or active.
+func (t *Theme) lineInks() [3][3]float64 {
+	if t.Inks != nil {
+		return t.Inks.Lines
+	}
+	return [3][3]float64{
+		scaledInk(t.ink(t.Success), lineInk),
+		scaledInk(t.ink(t.Failure), lineInk),
+		scaledInk(t.ink(t.Active), lineInk)}
+}
+
+// Get the ink for the hatching where the count lines drop off, which shades
+// the background to the grid color unless the theme sets it out explicitly.
+func (t *Theme) hatchInk() [3]float64 {
+	if t.Inks != nil {
+		return t.Inks.Hatch
+	}
+	return [3]float64{
+		float64(t.Grid.R) - float64(t.Background.R),
+		float64(t.Grid.G) - float64(t.Background.G),
+		float64(t.Grid.B) - float64(t.Background.B)}
+}
+
+// Utility function to scale an ink by the specified amount.
+func scaledInk(ink [3]float64, amount float64) [3]float64 {
+	return [3]float64{ink[0] * amount, ink[1] * amount, ink[2] * amount}
+}
+
+// Utility function to shift a pixel's color by the specified amount of ink,
+// clamping each channel to its valid range. An amount of one applies a full
+// step of ink.
+func tint(c *color.RGBA, ink [3]float64, amount float64) {
+	c.R = clampChannel(float64(c.R) + ink[0]*amount)
+	c.G = clampChannel(float64(c.G) + ink[1]*amount)
+	c.B = clampChannel(float64(c.B) + ink[2]*amount)
+}
+
+// Get the specified color as it would appear tinted over the background by
+// the specified amount of its own ink.
+func (t *Theme) tinted(c color.RGBA, amount float64) color.RGBA {
+	return t.inked(scaledInk(t.ink(c), amount))
+}
+
+// Get the background as it would appear tinted by a full step of the
+// specified ink.
+func (t *Theme) inked(ink [3]float64) color.RGBA {
+	bg := t.Background
+	tint(&bg, ink, 1)
+	return bg
+}
+
+// Get the shade representing a class of failures in a stack representing
+// multiple failure types.
+func (t *Theme) errorStackColor(layer int, layers int) color.RGBA {
+	return blend(t.ErrorRamp[0], t.ErrorRamp[1], float64(layer)/float64(layers))
+}
+
+// Get the color representing a class of failures in a density-style
+// visualization, falling back to the failure color for themes without error
+// hues.
+func (t *Theme) errorClassColor(class int) color.RGBA {
+	if len(t.ErrorHues) == 0 {
+		return t.Failure
+	}
+	return t.ErrorHues[class%len(t.ErrorHues)]
+}
+
+// Utility function to mix two colors, taking the specified fraction of the
+// second color.
+func blend(a color.RGBA, b color.RGBA, f float64) color.RGBA {
+	return color.RGBA{
+		clampChannel(float64(a.R) + (float64(b.R)-float64(a.R))*f),
+		clampChannel(float64(a.G) + (float64(b.G)-float64(a.G))*f),
+		clampChannel(float64(a.B) + (float64(b.B)-float64(a.B))*f),
+		opaque}
+}
+
+// Utility function to clamp a color channel value to the valid 8-bit range.
+func clampChannel(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(saturated, v)))
+}